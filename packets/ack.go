@@ -0,0 +1,102 @@
+package packets
+
+import "io"
+
+// Below are the helpers shared by PUBACK, PUBREC, PUBREL and PUBCOMP. On the
+// protocol level 5 the reason code follows the packet identifier, then the
+// properties, as in AUTH and DISCONNECT which have no packet identifier.
+
+// reasonLength returns the length of a reason code followed by properties.
+// A Success without properties is left out altogether, a reason code without
+// properties leaves out the properties length.
+func reasonLength(code ReasonCode, props *Properties) int {
+	switch {
+	case code == 0 && props == nil:
+		return 0
+	case props == nil:
+		return 1
+	}
+	return 1 + props.Size()
+}
+
+// packReason packs the reason code and the properties into b, in the form
+// reasonLength tells
+func packReason(b []byte, code ReasonCode, props *Properties) error {
+	n := reasonLength(code, props)
+	if n > 0 {
+		b[0] = byte(code)
+	}
+	if n > 1 {
+		_, err := props.Pack(b[1:])
+		return err
+	}
+	return nil
+}
+
+// unpackReason decodes the reason code and the properties at offset n of the
+// body b of fh, a Success without properties when left out
+func unpackReason(fh *FixedHeader, b []byte, n int) (ReasonCode, *Properties, error) {
+	switch {
+	case len(b) <= n:
+		return 0, nil, nil
+	case len(b) == n+1:
+		return ReasonCode(b[n]), nil, nil
+	}
+	props, _, err := unpackProperties(fh, b, n+1, fh.MessageType)
+	return ReasonCode(b[n]), props, err
+}
+
+// ackLength returns the remaining length of an acknowledgement
+func ackLength(fh *FixedHeader, code ReasonCode, props *Properties) int {
+	if fh.version() != MQTT5 {
+		return 2
+	}
+	return 2 + reasonLength(code, props)
+}
+
+// writeAck writes an acknowledgement into w with a single Write
+func writeAck(w io.Writer, fh *FixedHeader, id uint16, code ReasonCode, props *Properties) (int64, error) {
+	rl := ackLength(fh, code, props)
+	// 5 bytes reserved for the fixed header
+	b := getBuf(5 + rl)
+	defer putBuf(b)
+	if err := encodeUint16(id, b[5:]); err != nil {
+		return 0, err
+	}
+	if rl > 2 {
+		if err := packReason(b[7:], code, props); err != nil {
+			return 0, err
+		}
+	}
+	return writeFrame(w, fh, b)
+}
+
+// unpackAck decodes the body b of an acknowledgement
+func unpackAck(fh *FixedHeader, b []byte) (id uint16, code ReasonCode, props *Properties, err error) {
+	if len(b) < 2 {
+		return 0, 0, nil, malformed(fh, len(b), "truncated packet identifier")
+	}
+	id, _ = decodeUint16(b)
+	if fh.version() != MQTT5 {
+		return id, 0, nil, nil
+	}
+	code, props, err = unpackReason(fh, b, 2)
+	return id, code, props, err
+}
+
+// verifyAck checks an acknowledgement
+func verifyAck(fh *FixedHeader, id uint16, code ReasonCode, props *Properties) error {
+	if err := verifyMessageID(fh.MessageType, id); err != nil {
+		return err
+	}
+	if fh.version() != MQTT5 {
+		if code != 0 || props != nil {
+			return verifyError(fh.MessageType, "", "reason code or properties on protocol level %d", fh.version())
+		}
+		return nil
+	}
+	if !code.Valid(fh.MessageType, MQTT5) {
+		return verifyError(fh.MessageType, "", "unknown reason code 0x%02X", byte(code))
+	}
+	return props.Verify(fh.MessageType)
+}
@@ -0,0 +1,132 @@
+package packets
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var _authPacketPool = sync.Pool{
+	New: func() interface{} {
+		return &AuthPacket{FixedHeader: &FixedHeader{MessageType: Auth}}
+	},
+}
+
+// AuthPacket is an internal representation of the fields of the
+// Auth MQTT packet, which only exists on protocol level 5
+type AuthPacket struct {
+	*FixedHeader
+	ReasonCode ReasonCode
+	Properties *Properties
+	TraceID    string
+}
+
+// NewAuthPacket return the auth packet
+func NewAuthPacket() *AuthPacket {
+	return _authPacketPool.Get().(*AuthPacket)
+}
+
+// Reset will initialize the fields in control packet
+func (a *AuthPacket) Reset() {
+	a.FixedHeader.Dup = false
+	a.FixedHeader.QoS = byte(0)
+	a.FixedHeader.RemainingLength = 0
+	a.FixedHeader.Retain = false
+	a.FixedHeader.Version = 0
+	a.ReasonCode = 0
+	a.Properties = nil
+}
+
+// Close reset the packet field put the control packet back to pool
+func (a *AuthPacket) Close() {
+	a.Reset()
+	_authPacketPool.Put(a)
+}
+
+// SetTraceID will set traceid for tracing
+func (a *AuthPacket) SetTraceID(id string) { a.TraceID = id }
+
+// Verify checks the packet against the MQTT 5 specification
+func (a *AuthPacket) Verify() error {
+	if a.version() != MQTT5 {
+		return verifyError(Auth, "", "AUTH on protocol level %d", a.version())
+	}
+	if !a.ReasonCode.Valid(Auth, MQTT5) {
+		return verifyError(Auth, "", "unknown reason code 0x%02X", byte(a.ReasonCode))
+	}
+	// only the short form of a Success may leave the method out
+	if a.Method() == "" && (a.ReasonCode != ReasonSuccess || a.Properties != nil) {
+		return verifyError(Auth, "", "missing authentication method")
+	}
+	return a.Properties.Verify(Auth)
+}
+
+func (a *AuthPacket) verifyStrings(check UTF8Check) error {
+	return a.Properties.verifyStrings(Auth, check)
+}
+
+// Method returns the authentication method carried by the packet
+func (a *AuthPacket) Method() string {
+	if a.Properties == nil {
+		return ""
+	}
+	return a.Properties.AuthMethod
+}
+
+// Data returns the authentication data carried by the packet
+func (a *AuthPacket) Data() []byte {
+	if a.Properties == nil {
+		return nil
+	}
+	return a.Properties.AuthData
+}
+
+// SetFixedHeader will set fh for our header
+func (a *AuthPacket) SetFixedHeader(fh *FixedHeader) {
+	a.FixedHeader = fh
+}
+
+// Type return the packet type
+func (a *AuthPacket) Type() byte {
+	return a.FixedHeader.MessageType
+}
+
+// String export the packet of auth info
+func (a *AuthPacket) String() string {
+	return fmt.Sprintf("%s reasoncode: 0x%02X method: %s traceID: %s", a.FixedHeader, byte(a.ReasonCode), a.Method(), a.TraceID)
+}
+
+// Write will write the packets mostly into a net.Conn
+func (a *AuthPacket) Write(w io.Writer) (int, error) {
+	n, err := a.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (a *AuthPacket) WriteTo(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header
+	b := getBuf(5 + reasonLength(a.ReasonCode, a.Properties))
+	defer putBuf(b)
+	if err := packReason(b[5:], a.ReasonCode, a.Properties); err != nil {
+		return 0, err
+	}
+	return writeFrame(w, a.FixedHeader, b)
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (a *AuthPacket) Size() int {
+	return packetSize(reasonLength(a.ReasonCode, a.Properties))
+}
+
+// Unpack decodes the details of a ControlPacket after the fixed
+// header has been read
+func (a *AuthPacket) Unpack(b []byte) (err error) {
+	a.ReasonCode, a.Properties, err = unpackReason(a.FixedHeader, b, 0)
+	return err
+}
+
+// Details returns a Details struct containing the QoS and
+// MessageID of this ControlPacket
+func (a *AuthPacket) Details() Details {
+	return Details{QoS: 0, MessageID: 0}
+}
@@ -0,0 +1,54 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthPacket(t *testing.T) {
+	cp := NewAuthPacket()
+	cp.SetVersion(MQTT5)
+	assert.Equal(t, 2, cp.Size())
+	assert.NoError(t, cp.Verify())
+	cp.ReasonCode = ReasonContinueAuthentication
+	assert.Error(t, cp.Verify())
+	cp.Properties = &Properties{AuthMethod: "TEST", AuthData: []byte{1}}
+	assert.NoError(t, cp.Verify())
+	cp.ReasonCode = 0x01
+	assert.Error(t, cp.Verify())
+	cp.ReasonCode = ReasonContinueAuthentication
+	cp.Properties.ContentType = "text/plain"
+	assert.Error(t, cp.Verify())
+	cp.Properties.ContentType = ""
+
+	b, err := Marshal(cp)
+	assert.NoError(t, err)
+	assert.Equal(t, cp.Size(), len(b))
+	cp.Close()
+
+	d := NewDecoder(bytes.NewReader(b))
+	d.ProtocolVersion = MQTT5
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	a := packet.(*AuthPacket)
+	assert.Equal(t, ReasonContinueAuthentication, a.ReasonCode)
+	assert.Equal(t, "TEST", a.Method())
+	assert.Equal(t, []byte{1}, a.Data())
+	packet.Close()
+
+	// AUTH is a reserved packet type below the protocol level 5
+	_, _, err = Unmarshal(b)
+	assert.True(t, errors.Is(err, ErrUnknownPacketType))
+	cp = NewAuthPacket()
+	assert.Error(t, cp.Verify())
+	cp.Close()
+
+	// the flags are reserved on the protocol level 5
+	d = NewDecoder(bytes.NewReader([]byte{0xF1, 0}))
+	d.ProtocolVersion = MQTT5
+	_, err = d.Decode()
+	assert.True(t, errors.Is(err, ErrBadFlags))
+}
@@ -0,0 +1,226 @@
+package packets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotAuthorized is returned when an enhanced authentication fails or is
+// refused by the peer
+var ErrNotAuthorized = errors.New("not authorized")
+
+// Authenticator is one side of the enhanced authentication of the protocol
+// level 5, see section 4.12: a method specific challenge/response exchange of
+// authentication data, such as SCRAM. An Authenticator holds the state of a
+// single exchange.
+type Authenticator interface {
+	// Method returns the authentication method
+	Method() string
+	// Step consumes the authentication data received from the peer and
+	// returns the data to send back, the first step of a client takes nil.
+	// done reports the exchange is complete on this side, a failed
+	// authentication returns an error.
+	Step(data []byte) (response []byte, done bool, err error)
+}
+
+// AuthClient drives the client side of the enhanced authentication of a
+// connection, from the CONNECT to the CONNACK and on re-authentications.
+type AuthClient struct {
+	// Authenticator is the authenticator of the running exchange, it must
+	// be set to a fresh one before a re-authentication
+	Authenticator Authenticator
+
+	active bool
+	// done records the authenticator completed the exchange on its side
+	done bool
+}
+
+// Connect starts the exchange, it sets the authentication method and the
+// initial authentication data into the properties of c
+func (a *AuthClient) Connect(c *ConnectPacket) error {
+	data, done, err := a.Authenticator.Step(nil)
+	if err != nil {
+		return err
+	}
+	if c.Properties == nil {
+		c.Properties = &Properties{}
+	}
+	c.Properties.AuthMethod = a.Authenticator.Method()
+	c.Properties.AuthData = data
+	a.active, a.done = true, done
+	return nil
+}
+
+// Reauthenticate starts a re-authentication of the connection and returns
+// the AUTH to send
+func (a *AuthClient) Reauthenticate() (*AuthPacket, error) {
+	if a.active {
+		return nil, verifyError(Auth, "", "authentication in progress")
+	}
+	data, done, err := a.Authenticator.Step(nil)
+	if err != nil {
+		return nil, err
+	}
+	a.active, a.done = true, done
+	return newAuthPacket(ReasonReauthenticate, a.Authenticator.Method(), data), nil
+}
+
+// Handle handles a CONNACK or an AUTH received from the server during the
+// exchange. It returns the AUTH to reply with, if any, and whether the
+// exchange is over. The authentication data of a Success is only handed to
+// the authenticator when the server sent some, a Success received before the
+// authenticator completed the exchange fails with ErrNotAuthorized.
+func (a *AuthClient) Handle(cp ControlPacket) (reply *AuthPacket, done bool, err error) {
+	if !a.active {
+		return nil, true, verifyError(cp.Type(), "", "no authentication in progress")
+	}
+	var (
+		code  ReasonCode
+		props *Properties
+	)
+	switch p := cp.(type) {
+	case *ConnackPacket:
+		code, props = ReasonCode(p.ReturnCode), p.Properties
+		if code.IsError() {
+			a.active = false
+			return nil, true, fmt.Errorf("%w: %s", ErrNotAuthorized, code)
+		}
+	case *AuthPacket:
+		code, props = p.ReasonCode, p.Properties
+		if code == ReasonReauthenticate {
+			a.active = false
+			return nil, true, verifyError(Auth, "", "re-authenticate sent by the server")
+		}
+	default:
+		a.active = false
+		return nil, true, verifyError(cp.Type(), "", "%s during the authentication", PacketNames[cp.Type()&0x0F])
+	}
+
+	method, data := authProperties(props)
+	if method != a.Authenticator.Method() {
+		a.active = false
+		return nil, true, verifyError(cp.Type(), "", "authentication method %q, %q expected", method, a.Authenticator.Method())
+	}
+	if code == ReasonContinueAuthentication || data != nil {
+		if data, a.done, err = a.Authenticator.Step(data); err != nil {
+			a.active = false
+			return nil, true, err
+		}
+	}
+	if code == ReasonContinueAuthentication {
+		return newAuthPacket(ReasonContinueAuthentication, method, data), false, nil
+	}
+	a.active = false
+	if !a.done {
+		// the server did not prove itself
+		return nil, true, fmt.Errorf("%w: success before the end of the exchange", ErrNotAuthorized)
+	}
+	return nil, true, nil
+}
+
+// AuthServer drives the server side of the enhanced authentication of a
+// connection, from the CONNECT to the CONNACK and on re-authentications.
+type AuthServer struct {
+	// NewAuthenticator returns a fresh authenticator of method, nil when the
+	// method is not supported
+	NewAuthenticator func(method string) Authenticator
+
+	auth   Authenticator
+	method string
+	reauth bool
+	active bool
+}
+
+// Handle handles a CONNECT or an AUTH received from the client. It returns
+// the packet to reply with: an AUTH continuing the exchange, then the CONNACK,
+// or the AUTH Success of a re-authentication, concluding it. done reports the
+// exchange is over. On an error the connection must be closed after sending
+// the reply if any, the refusing CONNACK or DISCONNECT on a re-authentication.
+// A CONNECT without authentication method returns no reply and done, the
+// connection does not use enhanced authentication.
+func (s *AuthServer) Handle(cp ControlPacket) (reply ControlPacket, done bool, err error) {
+	switch p := cp.(type) {
+	case *ConnectPacket:
+		method, data := authProperties(p.Properties)
+		s.method, s.reauth, s.active = method, false, false
+		if method == "" {
+			return nil, true, nil
+		}
+		if s.auth = s.NewAuthenticator(method); s.auth == nil {
+			return newConnackPacket(ReasonBadAuthenticationMethod, method, nil), true,
+				fmt.Errorf("%w: unsupported authentication method %q", ErrNotAuthorized, method)
+		}
+		s.active = true
+		return s.step(data)
+	case *AuthPacket:
+		method, data := authProperties(p.Properties)
+		switch {
+		case s.method == "":
+			return nil, true, verifyError(Auth, "", "AUTH without enhanced authentication")
+		case method != s.method:
+			s.active = false
+			return nil, true, verifyError(Auth, "", "authentication method %q, %q expected", method, s.method)
+		case p.ReasonCode == ReasonReauthenticate && !s.active:
+			if s.auth = s.NewAuthenticator(method); s.auth == nil {
+				return nil, true, fmt.Errorf("%w: unsupported authentication method %q", ErrNotAuthorized, method)
+			}
+			s.reauth, s.active = true, true
+		case p.ReasonCode != ReasonContinueAuthentication || !s.active:
+			s.active = false
+			return nil, true, verifyError(Auth, "", "unexpected reason code 0x%02X", byte(p.ReasonCode))
+		}
+		return s.step(data)
+	}
+	return nil, true, verifyError(cp.Type(), "", "%s during the authentication", PacketNames[cp.Type()&0x0F])
+}
+
+// step hands data to the authenticator and builds the reply
+func (s *AuthServer) step(data []byte) (ControlPacket, bool, error) {
+	data, done, err := s.auth.Step(data)
+	switch {
+	case err != nil:
+		s.active = false
+		if s.reauth {
+			d := NewDisconnectPacket()
+			d.SetVersion(MQTT5)
+			d.ReasonCode = ReasonNotAuthorized
+			return d, true, err
+		}
+		return newConnackPacket(ReasonNotAuthorized, s.method, nil), true, err
+	case !done:
+		return newAuthPacket(ReasonContinueAuthentication, s.method, data), false, nil
+	}
+	s.active = false
+	if s.reauth {
+		return newAuthPacket(ReasonSuccess, s.method, data), true, nil
+	}
+	return newConnackPacket(ReasonSuccess, s.method, data), true, nil
+}
+
+// authProperties returns the authentication method and data of props
+func authProperties(props *Properties) (string, []byte) {
+	if props == nil {
+		return "", nil
+	}
+	return props.AuthMethod, props.AuthData
+}
+
+// newAuthPacket returns an AUTH of protocol level 5 carrying the method and
+// the data
+func newAuthPacket(code ReasonCode, method string, data []byte) *AuthPacket {
+	a := NewAuthPacket()
+	a.SetVersion(MQTT5)
+	a.ReasonCode = code
+	a.Properties = &Properties{AuthMethod: method, AuthData: data}
+	return a
+}
+
+// newConnackPacket returns a CONNACK of protocol level 5 carrying the method
+// and the data
+func newConnackPacket(code ReasonCode, method string, data []byte) *ConnackPacket {
+	ca := NewConnackPacket()
+	ca.SetVersion(MQTT5)
+	ca.ReturnCode = byte(code)
+	ca.Properties = &Properties{AuthMethod: method, AuthData: data}
+	return ca
+}
@@ -0,0 +1,136 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// challengeAuthenticator is a toy method: the server sends a challenge, the
+// client answers it with the secret appended, and the server proves it knows
+// the secret too with the final data
+type challengeAuthenticator struct {
+	server bool
+	secret string
+	steps  int
+}
+
+func (c *challengeAuthenticator) Method() string { return "CHALLENGE" }
+
+func (c *challengeAuthenticator) Step(data []byte) ([]byte, bool, error) {
+	c.steps++
+	switch {
+	case !c.server && c.steps == 1:
+		return []byte("hello"), false, nil
+	case !c.server && c.steps == 2:
+		return append(data, c.secret...), false, nil
+	case !c.server:
+		return nil, true, check(string(data) == "ok "+c.secret)
+	case c.steps == 1:
+		return []byte("nonce"), false, check(string(data) == "hello")
+	}
+	return []byte("ok " + c.secret), true, check(string(data) == "nonce"+c.secret)
+}
+
+func check(ok bool) error {
+	if !ok {
+		return ErrNotAuthorized
+	}
+	return nil
+}
+
+func TestAuthExchange(t *testing.T) {
+	client := &AuthClient{Authenticator: &challengeAuthenticator{secret: "s"}}
+	server := &AuthServer{NewAuthenticator: func(method string) Authenticator {
+		if method != "CHALLENGE" {
+			return nil
+		}
+		return &challengeAuthenticator{server: true, secret: "s"}
+	}}
+
+	c := NewConnectPacket()
+	c.ProtocolName = "MQTT"
+	c.ProtocolVersion = MQTT5
+	c.CleanSession = true
+	assert.NoError(t, client.Connect(c))
+	assert.Equal(t, "CHALLENGE", c.Properties.AuthMethod)
+
+	// CONNECT, AUTH, AUTH, CONNACK
+	reply, done, err := server.Handle(roundTrip(t, c))
+	assert.NoError(t, err)
+	assert.False(t, done)
+	sent, done, err := client.Handle(roundTrip(t, reply))
+	assert.NoError(t, err)
+	assert.False(t, done)
+	reply, done, err = server.Handle(roundTrip(t, sent))
+	assert.NoError(t, err)
+	assert.True(t, done)
+	ca := reply.(*ConnackPacket)
+	assert.Equal(t, byte(Accepted), ca.ReturnCode)
+	assert.Equal(t, []byte("ok s"), ca.Properties.AuthData)
+	_, done, err = client.Handle(roundTrip(t, ca))
+	assert.NoError(t, err)
+	assert.True(t, done)
+
+	// a Success without the proof of the server is refused
+	client.Authenticator = &challengeAuthenticator{secret: "s"}
+	_, err = client.Reauthenticate()
+	assert.NoError(t, err)
+	_, _, err = client.Handle(roundTrip(t, newAuthPacket(ReasonContinueAuthentication, "CHALLENGE", []byte("nonce"))))
+	assert.NoError(t, err)
+	_, done, err = client.Handle(roundTrip(t, newAuthPacket(ReasonSuccess, "CHALLENGE", nil)))
+	assert.True(t, errors.Is(err, ErrNotAuthorized))
+	assert.True(t, done)
+
+	// re-authentication with a wrong secret, AUTH, AUTH, AUTH
+	client.Authenticator = &challengeAuthenticator{secret: "x"}
+	a, err := client.Reauthenticate()
+	assert.NoError(t, err)
+	reply, _, err = server.Handle(roundTrip(t, a))
+	assert.NoError(t, err)
+	sent, _, err = client.Handle(roundTrip(t, reply))
+	assert.NoError(t, err)
+	reply, done, err = server.Handle(roundTrip(t, sent))
+	assert.True(t, errors.Is(err, ErrNotAuthorized))
+	assert.True(t, done)
+	assert.Equal(t, ReasonNotAuthorized, roundTrip(t, reply).(*DisconnectPacket).ReasonCode)
+
+	// a CONNECT without method does not use enhanced authentication
+	c = NewConnectPacket()
+	reply, done, err = server.Handle(c)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Nil(t, reply)
+	_, _, err = server.Handle(newAuthPacket(ReasonReauthenticate, "CHALLENGE", nil))
+	assert.Error(t, err)
+
+	// an unsupported method is refused
+	c.Properties = &Properties{AuthMethod: "PLAIN"}
+	reply, done, err = server.Handle(c)
+	assert.True(t, errors.Is(err, ErrNotAuthorized))
+	assert.True(t, done)
+	assert.Equal(t, byte(0x8C), reply.(*ConnackPacket).ReturnCode)
+	_, _, err = client.Handle(reply)
+	assert.Error(t, err)
+}
+
+// roundTrip encodes cp and decodes it back on a connection of protocol
+// level 5
+func roundTrip(t *testing.T, cp ControlPacket) ControlPacket {
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	e.ProtocolVersion = MQTT5
+	if err := e.Encode(cp); err != nil {
+		t.Fatalf("encode %s: %v", cp, err)
+	}
+	d := NewDecoder(&b)
+	d.ProtocolVersion = MQTT5
+	d.Strict = true
+	got, err := d.Decode()
+	if err != nil {
+		t.Fatalf("decode %s: %v", cp, err)
+	}
+	return got
+}
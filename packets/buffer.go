@@ -1,31 +1,139 @@
 package packets
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
-var _bufPool sync.Pool
+// Below are the size classes of SizedPool, buffers from 16 bytes to 1 MiB
+// are pooled in power of two classes
+const (
+	minClassShift = 4
+	maxClassShift = 20
+)
 
-type leakBuffer struct {
-	b []byte
+// BufferPool is the byte slice pool used by the packets on both read and
+// write paths. A custom pool can be injected with SetBufferPool.
+type BufferPool interface {
+	// Get returns a buffer with a length of size
+	Get(size int) []byte
+	// Put gives back a buffer got from Get, it must not be used any more
+	Put(b []byte)
 }
 
-// NewBufferPool creates a leaky buffer which can hold at most n buffer, each
-// with bufSize bytes.
-func NewBufferPool(bufSize int) {
-	_bufPool = sync.Pool{
-		New: func() interface{} {
-			return &leakBuffer{make([]byte, bufSize)}
-		},
+// PoolStats is a snapshot of the counters of a SizedPool
+type PoolStats struct {
+	// Hits counts the Get served by a pooled buffer
+	Hits uint64
+	// Misses counts the Get served by an allocation
+	Misses uint64
+	// Outstanding is the capacity in bytes got and not put back yet
+	Outstanding int64
+}
+
+// SizedPool is a BufferPool with a sync.Pool per power of two size class.
+// Buffers over the largest class are allocated and dropped.
+type SizedPool struct {
+	classes [maxClassShift - minClassShift + 1]sync.Pool
+	// slots holds spare *[]byte, so putting a buffer into a class does
+	// not allocate a new pointer every time
+	slots sync.Pool
+
+	hits        uint64
+	misses      uint64
+	outstanding int64
+}
+
+// NewSizedPool returns an empty SizedPool
+func NewSizedPool() *SizedPool {
+	return &SizedPool{}
+}
+
+// sizeClass returns the class index of a buffer of size bytes, -1 for the
+// buffers too large to be pooled
+func sizeClass(size int) int {
+	c := 0
+	for s := 1 << minClassShift; s < size; s <<= 1 {
+		c++
+	}
+	if c > maxClassShift-minClassShift {
+		return -1
+	}
+	return c
+}
+
+// Get returns a buffer with a length of size from the pool
+func (p *SizedPool) Get(size int) []byte {
+	c := sizeClass(size)
+	if c < 0 {
+		atomic.AddUint64(&p.misses, 1)
+		atomic.AddInt64(&p.outstanding, int64(size))
+		return make([]byte, size)
+	}
+
+	var b []byte
+	if v := p.classes[c].Get(); v != nil {
+		slot := v.(*[]byte)
+		b = *slot
+		*slot = nil
+		p.slots.Put(slot)
+		atomic.AddUint64(&p.hits, 1)
+	} else {
+		b = make([]byte, 1<<uint(c+minClassShift))
+		atomic.AddUint64(&p.misses, 1)
+	}
+	atomic.AddInt64(&p.outstanding, int64(cap(b)))
+	return b[:size]
+}
+
+// Put gives the buffer back to its size class
+func (p *SizedPool) Put(b []byte) {
+	atomic.AddInt64(&p.outstanding, -int64(cap(b)))
+	c := sizeClass(cap(b))
+	if c < 0 || cap(b) != 1<<uint(c+minClassShift) {
+		// not a buffer of ours
+		return
+	}
+
+	slot, _ := p.slots.Get().(*[]byte)
+	if slot == nil {
+		slot = new([]byte)
+	}
+	*slot = b[:cap(b)]
+	p.classes[c].Put(slot)
+}
+
+// Stats returns the counters of the pool
+func (p *SizedPool) Stats() PoolStats {
+	return PoolStats{
+		Hits:        atomic.LoadUint64(&p.hits),
+		Misses:      atomic.LoadUint64(&p.misses),
+		Outstanding: atomic.LoadInt64(&p.outstanding),
+	}
+}
+
+var (
+	// DefaultBufferPool is the pool used unless SetBufferPool was called
+	DefaultBufferPool = NewSizedPool()
+
+	_bufPool BufferPool = DefaultBufferPool
+)
+
+// SetBufferPool injects the pool used by the package, nil restores
+// DefaultBufferPool. It must be called before any packet is read or written.
+func SetBufferPool(p BufferPool) {
+	if p == nil {
+		p = DefaultBufferPool
 	}
+	_bufPool = p
 }
 
-// Getbuf returns a buffer from the leaky buffer or create a new buffer.
-func Getbuf() (b *leakBuffer) {
-	return _bufPool.Get().(*leakBuffer)
+// getBuf returns a buffer with a length of size from the package pool
+func getBuf(size int) []byte {
+	return _bufPool.Get(size)
 }
 
-// Putbuf add the buffer into the free buffer pool for reuse. Panic if the buffer
-// size is not the same with the leaky buffer's. This is intended to expose
-// error usage of leaky buffer.
-func Putbuf(b *leakBuffer) {
+// putBuf gives the buffer back to the package pool
+func putBuf(b []byte) {
 	_bufPool.Put(b)
 }
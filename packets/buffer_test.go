@@ -0,0 +1,65 @@
+package packets
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizedPool(t *testing.T) {
+	p := NewSizedPool()
+	b := p.Get(7)
+	assert.Equal(t, 7, len(b))
+	assert.Equal(t, 16, cap(b))
+	assert.Equal(t, PoolStats{Misses: 1, Outstanding: 16}, p.Stats())
+	p.Put(b)
+	assert.Equal(t, int64(0), p.Stats().Outstanding)
+
+	b = p.Get(1000)
+	assert.Equal(t, 1000, len(b))
+	assert.Equal(t, 1024, cap(b))
+	p.Put(b)
+
+	// buffers over the largest class are not pooled
+	b = p.Get(1<<maxClassShift + 1)
+	assert.Equal(t, 1<<maxClassShift+1, cap(b))
+	p.Put(b)
+
+	stats := p.Stats()
+	assert.Equal(t, uint64(3), stats.Hits+stats.Misses)
+	assert.Equal(t, int64(0), stats.Outstanding)
+}
+
+// countPool is a BufferPool counting the buffers in use
+type countPool struct {
+	gets, puts int
+}
+
+func (p *countPool) Get(size int) []byte {
+	p.gets++
+	return make([]byte, size)
+}
+
+func (p *countPool) Put([]byte) { p.puts++ }
+
+func TestSetBufferPool(t *testing.T) {
+	pool := &countPool{}
+	SetBufferPool(pool)
+	defer SetBufferPool(nil)
+
+	cp := NewPublishPacket()
+	cp.TopicName = "a/b"
+	cp.Payload = []byte("hi")
+	buf := bytes.Buffer{}
+	_, err := cp.Write(&buf)
+	assert.NoError(t, err)
+	cp.Close()
+
+	packet, _, err := ReadPacket(&buf)
+	assert.NoError(t, err)
+	packet.Close()
+
+	assert.True(t, pool.gets >= 2)
+	assert.Equal(t, pool.gets, pool.puts)
+}
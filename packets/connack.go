@@ -17,8 +17,12 @@ var _connackPacketPool = sync.Pool{
 type ConnackPacket struct {
 	*FixedHeader
 	SessionPresent bool
-	ReturnCode     byte
-	TraceID        string
+	// ReturnCode is the reason code on protocol level 5
+	ReturnCode byte
+	TraceID    string
+
+	// Properties are the CONNACK properties of protocol level 5
+	Properties *Properties
 }
 
 // NewConnackPacket return the connect ack packet
@@ -32,9 +36,11 @@ func (ca *ConnackPacket) Reset() {
 	ca.FixedHeader.QoS = byte(0)
 	ca.FixedHeader.RemainingLength = 0
 	ca.FixedHeader.Retain = false
+	ca.FixedHeader.Version = 0
 
 	ca.SessionPresent = false
 	ca.ReturnCode = byte(0)
+	ca.Properties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
@@ -51,8 +57,34 @@ func (ca *ConnackPacket) SetFixedHeader(fh *FixedHeader) {
 // SetTraceID will set traceid for tracing
 func (ca *ConnackPacket) SetTraceID(id string) { ca.TraceID = id }
 
-// Verify packet availability
-func (ca *ConnackPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (ca *ConnackPacket) Verify() error {
+	if ca.version() == MQTT5 {
+		if !ReasonCode(ca.ReturnCode).Valid(Connack, MQTT5) {
+			return verifyError(Connack, "", "unknown reason code 0x%02X", ca.ReturnCode)
+		}
+		if ca.ReturnCode >= 0x80 && ca.SessionPresent {
+			return verifyError(Connack, "MQTT-3.2.2-6", "session present set on a refused connection")
+		}
+		return ca.Properties.Verify(Connack)
+	}
+
+	if !ReasonCode(ca.ReturnCode).Valid(Connack, MQTT311) {
+		return verifyError(Connack, "", "unknown return code %d", ca.ReturnCode)
+	}
+	if ca.ReturnCode != Accepted && ca.SessionPresent {
+		return verifyError(Connack, "MQTT-3.2.2-4", "session present set on a refused connection")
+	}
+	if ca.Properties != nil {
+		return verifyError(Connack, "", "properties on protocol level %d", ca.version())
+	}
+	return nil
+}
+
+func (ca *ConnackPacket) verifyStrings(check UTF8Check) error {
+	return ca.Properties.verifyStrings(Connack, check)
+}
 
 // Type return the packet type
 func (ca *ConnackPacket) Type() byte {
@@ -64,24 +96,57 @@ func (ca *ConnackPacket) Type() byte {
 // | --- SessionPresent --- |
 // | ---   ReturnCode   --- |
 // Connack is a specify length packet. So we only use one bytes
-func (ca *ConnackPacket) Write(w io.Writer) (length int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
+func (ca *ConnackPacket) Write(w io.Writer) (int, error) {
+	n, err := ca.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (ca *ConnackPacket) WriteTo(w io.Writer) (int64, error) {
+	if ca.version() == MQTT5 {
+		return ca.writeV5(w)
+	}
+	b := getBuf(7)
+	defer putBuf(b)
 	ca.FixedHeader.RemainingLength = 2
-	ca.FixedHeader.pack(b.b[:5])
-	b.b[5], b.b[6] = boolToByte(ca.SessionPresent), ca.ReturnCode
-	return w.Write(b.b[3:7])
+	ca.FixedHeader.pack(b[:5])
+	b[5], b[6] = boolToByte(ca.SessionPresent), ca.ReturnCode
+	n, err := w.Write(b[3:7])
+	return int64(n), err
+}
+
+// writeV5 writes the packet of protocol level 5, properties included
+func (ca *ConnackPacket) writeV5(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header
+	b := getBuf(5 + 2 + ca.Properties.Size())
+	defer putBuf(b)
+	b[5], b[6] = boolToByte(ca.SessionPresent), ca.ReturnCode
+	if _, err := ca.Properties.Pack(b[7:]); err != nil {
+		return 0, err
+	}
+	return writeFrame(w, ca.FixedHeader, b)
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (ca *ConnackPacket) Size() int {
+	if ca.version() == MQTT5 {
+		return packetSize(2 + ca.Properties.Size())
+	}
+	return packetSize(2)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
 // header has been read
-func (ca *ConnackPacket) Unpack(b []byte) error {
+func (ca *ConnackPacket) Unpack(b []byte) (err error) {
 	if len(b) < 2 {
-		return io.ErrShortBuffer
+		return malformed(ca.FixedHeader, len(b), "truncated variable header")
 	}
 	ca.SessionPresent = 0x01&b[0] > 0
 	ca.ReturnCode = b[1]
-	return nil
+	if ca.version() == MQTT5 && len(b) > 2 {
+		ca.Properties, _, err = unpackProperties(ca.FixedHeader, b, 2, Connack)
+	}
+	return err
 }
 
 // Details returns a Details struct containing the QoS and
@@ -33,3 +33,30 @@ func TestConnackPacket(t *testing.T) {
 
 	packet.Close()
 }
+
+func TestConnackPacketV5(t *testing.T) {
+	cp := NewConnackPacket()
+	cp.SetVersion(MQTT5)
+	cp.ReturnCode = 0x8A
+	assert.NoError(t, cp.Verify())
+	cp.SessionPresent = true
+	assert.Error(t, cp.Verify())
+	cp.SessionPresent = false
+	cp.ReturnCode = 0x01
+	assert.Error(t, cp.Verify())
+	cp.Close()
+
+	// a CONNACK of protocol level 5 without properties
+	d := NewDecoder(bytes.NewReader([]byte{32, 2, 0, 0x88}))
+	d.ProtocolVersion = MQTT5
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	assert.Nil(t, packet.(*ConnackPacket).Properties)
+	packet.Close()
+
+	// properties are not allowed below the protocol level 5
+	cp = NewConnackPacket()
+	cp.Properties = &Properties{ReasonString: "no"}
+	assert.Error(t, cp.Verify())
+	cp.Close()
+}
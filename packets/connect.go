@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"sync"
+
+	"github.com/arthurkiller/mqtgo/topic"
 )
 
 var _connectPacketPool = sync.Pool{
@@ -37,6 +39,15 @@ type ConnectPacket struct {
 	Username         string
 	Password         []byte
 	TraceID          string
+
+	// Properties are the CONNECT properties of protocol level 5
+	Properties *Properties
+	// WillProperties are the will properties of protocol level 5, carried
+	// with the will
+	WillProperties *Properties
+
+	// frame holds the buffer aliased by a zero-copy decoded packet
+	frame *frame
 }
 
 // NewConnectPacket return the connect packet
@@ -50,6 +61,7 @@ func (c *ConnectPacket) Reset() {
 	c.FixedHeader.QoS = byte(0)
 	c.FixedHeader.RemainingLength = 0
 	c.FixedHeader.Retain = false
+	c.FixedHeader.Version = 0
 
 	c.ProtocolName = ""
 	c.ProtocolVersion = byte(0)
@@ -67,11 +79,17 @@ func (c *ConnectPacket) Reset() {
 	c.WillMessage = []byte{}
 	c.Username = ""
 	c.Password = []byte{}
+	c.Properties = nil
+	c.WillProperties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
 func (c *ConnectPacket) Close() {
 	c.Reset()
+	if c.frame != nil {
+		putFrame(c.frame)
+		c.frame = nil
+	}
 	_connectPacketPool.Put(c)
 }
 
@@ -83,8 +101,94 @@ func (c *ConnectPacket) SetFixedHeader(fh *FixedHeader) {
 // SetTraceID will set traceid for tracing
 func (c *ConnectPacket) SetTraceID(id string) { c.TraceID = id }
 
-// Verify packet availability
-func (c *ConnectPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification
+func (c *ConnectPacket) Verify() error {
+	if c.ProtocolName != "MQIsdp" && c.ProtocolName != "MQTT" {
+		return verifyError(Connect, "MQTT-3.1.2-1", "bad protocol name %q", c.ProtocolName)
+	}
+	if !supportedVersion(c.ProtocolName, c.ProtocolVersion) {
+		return verifyError(Connect, "MQTT-3.1.2-2", "unsupported protocol version %d of %s", c.ProtocolVersion, c.ProtocolName)
+	}
+	if c.ReservedBit != 0 {
+		return verifyError(Connect, "MQTT-3.1.2-3", "reserved flag set")
+	}
+	if c.WillQoS > 2 {
+		return verifyError(Connect, "MQTT-3.1.2-14", "invalid will QoS %d", c.WillQoS)
+	}
+	if !c.WillFlag && c.WillQoS != 0 {
+		return verifyError(Connect, "MQTT-3.1.2-13", "will QoS set without will flag")
+	}
+	if !c.WillFlag && c.WillRetain {
+		return verifyError(Connect, "MQTT-3.1.2-15", "will retain set without will flag")
+	}
+	if c.PasswordFlag && !c.UsernameFlag && c.ProtocolVersion != MQTT5 {
+		return verifyError(Connect, "MQTT-3.1.2-22", "password flag set without username flag")
+	}
+	if err := c.verifyProperties(); err != nil {
+		return err
+	}
+	if len(c.ClientIdentifier) == 0 && !c.CleanSession && c.ProtocolVersion != MQTT5 {
+		return verifyError(Connect, "MQTT-3.1.3-7", "empty client identifier without clean session")
+	}
+	if len(c.ClientIdentifier) > 65535 || len(c.WillTopic) > 65535 || len(c.WillMessage) > 65535 ||
+		len(c.Username) > 65535 || len(c.Password) > 65535 {
+		return verifyError(Connect, "", "field longer than 65535 bytes")
+	}
+	if err := c.verifyTopics(&specTopics); err != nil {
+		return err
+	}
+	return c.verifyStrings(UTF8CheckSpec)
+}
+
+// verifyTopics checks the will topic, the topic name of the will message
+func (c *ConnectPacket) verifyTopics(v *topic.Validator) error {
+	if !c.WillFlag {
+		return nil
+	}
+	return verifyTopicName(Connect, v, c.WillTopic)
+}
+
+// verifyProperties checks the properties are carried by a CONNECT of
+// protocol level 5 only and are valid
+func (c *ConnectPacket) verifyProperties() error {
+	if c.ProtocolVersion != MQTT5 {
+		if c.Properties != nil || c.WillProperties != nil {
+			return verifyError(Connect, "", "properties on protocol level %d", c.ProtocolVersion)
+		}
+		return nil
+	}
+	if !c.WillFlag && c.WillProperties != nil {
+		return verifyError(Connect, "", "will properties without will flag")
+	}
+	if err := c.Properties.Verify(Connect); err != nil {
+		return err
+	}
+	return c.WillProperties.Verify(WillProperties)
+}
+
+func (c *ConnectPacket) verifyStrings(check UTF8Check) error {
+	if err := verifyString(Connect, "protocol name", c.ProtocolName, check); err != nil {
+		return err
+	}
+	if err := verifyString(Connect, "client identifier", c.ClientIdentifier, check); err != nil {
+		return err
+	}
+	if err := c.Properties.verifyStrings(Connect, check); err != nil {
+		return err
+	}
+	if err := c.WillProperties.verifyStrings(Connect, check); err != nil {
+		return err
+	}
+	if c.WillFlag {
+		if err := verifyString(Connect, "will topic", c.WillTopic, check); err != nil {
+			return err
+		}
+	}
+	if c.UsernameFlag {
+		return verifyString(Connect, "username", c.Username, check)
+	}
+	return nil
+}
 
 // Type return the packet type
 func (c *ConnectPacket) Type() byte {
@@ -92,12 +196,22 @@ func (c *ConnectPacket) Type() byte {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (c *ConnectPacket) Write(w io.Writer) (n int, err error) {
-	cb := make([]byte, len(c.ProtocolName)+len(c.ClientIdentifier)+len(c.WillTopic)+len(c.WillMessage)+len(c.Username)+len(c.Password)+16)
-	if err = encodeString(c.ProtocolName, cb); err != nil {
+func (c *ConnectPacket) Write(w io.Writer) (int, error) {
+	n, err := c.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (c *ConnectPacket) WriteTo(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header
+	cb := getBuf(5 + len(c.ProtocolName) + len(c.ClientIdentifier) + len(c.WillTopic) + len(c.WillMessage) + len(c.Username) + len(c.Password) + 16 +
+		c.propertiesSize())
+	defer putBuf(cb)
+	n := 5
+	if err := encodeString(c.ProtocolName, cb[n:]); err != nil {
 		return 0, err
 	}
-	n = len(c.ProtocolName) + 2 // 8byte
+	n += len(c.ProtocolName) + 2
 
 	cb[n] = c.ProtocolVersion
 	n++
@@ -106,64 +220,112 @@ func (c *ConnectPacket) Write(w io.Writer) (n int, err error) {
 		boolToByte(c.WillRetain)<<5 | boolToByte(c.PasswordFlag)<<6 | boolToByte(c.UsernameFlag)<<7)
 	n++
 
-	if err = encodeUint16(c.Keepalive, cb[n:]); err != nil {
+	if err := encodeUint16(c.Keepalive, cb[n:]); err != nil {
 		return 0, err
 	}
-	n += 2 // 12byte
+	n += 2
 
-	if err = encodeString(c.ClientIdentifier, cb[n:]); err != nil {
+	if c.ProtocolVersion == MQTT5 {
+		m, err := c.Properties.Pack(cb[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
+
+	if err := encodeString(c.ClientIdentifier, cb[n:]); err != nil {
 		return 0, err
 	}
 	n += len(c.ClientIdentifier) + 2
 
 	if c.WillFlag {
-		if err = encodeString(c.WillTopic, cb[n:]); err != nil {
+		if c.ProtocolVersion == MQTT5 {
+			m, err := c.WillProperties.Pack(cb[n:])
+			if err != nil {
+				return 0, err
+			}
+			n += m
+		}
+		if err := encodeString(c.WillTopic, cb[n:]); err != nil {
 			return 0, err
 		}
 		n += len(c.WillTopic) + 2
-		if err = encodeBytes(c.WillMessage, cb[n:]); err != nil {
+		if err := encodeBytes(c.WillMessage, cb[n:]); err != nil {
 			return 0, err
 		}
 		n += len(c.WillMessage) + 2
 	}
 	if c.UsernameFlag {
-		if err = encodeString(c.Username, cb[n:]); err != nil {
+		if err := encodeString(c.Username, cb[n:]); err != nil {
 			return 0, err
 		}
 		n += len(c.Username) + 2
 	}
 	if c.PasswordFlag {
-		if err = encodeBytes(c.Password, cb[n:]); err != nil {
+		if err := encodeBytes(c.Password, cb[n:]); err != nil {
 			return 0, err
 		}
 		n += len(c.Password) + 2
 	}
-	c.FixedHeader.RemainingLength = n
+	return writeFrame(w, c.FixedHeader, cb[:n])
+}
 
-	b := Getbuf()
-	defer Putbuf(b)
-	m := c.FixedHeader.pack(b.b[:5])
-	if m, err = w.Write(b.b[5-m : 5]); err != nil {
-		return m, err
+// Size returns the length of the packet on wire, fixed header included
+func (c *ConnectPacket) Size() int {
+	rl := 2 + len(c.ProtocolName) + 4 + 2 + len(c.ClientIdentifier) + c.propertiesSize()
+	if c.WillFlag {
+		rl += 2 + len(c.WillTopic) + 2 + len(c.WillMessage)
+	}
+	if c.UsernameFlag {
+		rl += 2 + len(c.Username)
 	}
+	if c.PasswordFlag {
+		rl += 2 + len(c.Password)
+	}
+	return packetSize(rl)
+}
 
-	n, err = w.Write(cb[:n])
-	return n + m, err
+// propertiesSize returns the length of the properties and will properties
+// on wire, 0 below protocol level 5
+func (c *ConnectPacket) propertiesSize() int {
+	if c.ProtocolVersion != MQTT5 {
+		return 0
+	}
+	n := c.Properties.Size()
+	if c.WillFlag {
+		n += c.WillProperties.Size()
+	}
+	return n
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
 // header has been read
 func (c *ConnectPacket) Unpack(b []byte) error {
+	return c.unpack(b, false)
+}
+
+// unpackFrame decodes the packet with all the strings and bytes fields
+// aliasing b, f owning b will be released on Close
+func (c *ConnectPacket) unpackFrame(b []byte, f *frame) error {
+	c.frame = f
+	return c.unpack(b, true)
+}
+
+func (c *ConnectPacket) unpack(b []byte, alias bool) error {
 	var (
 		n, m int
 		err  error
 	)
-	c.ProtocolName, n, err = decodeString(b)
+	str, bs := decodeString, decodeBytesCopy
+	if alias {
+		str, bs = decodeStringNoCopy, decodeBytes
+	}
+	c.ProtocolName, n, err = str(b)
 	if err != nil {
-		return err
+		return malformed(c.FixedHeader, 0, "truncated protocol name")
 	}
 	if len(b) < n+4 {
-		return io.ErrShortBuffer
+		return malformed(c.FixedHeader, len(b), "truncated variable header")
 	}
 	c.ProtocolVersion = b[n]
 	n++
@@ -178,73 +340,101 @@ func (c *ConnectPacket) Unpack(b []byte) error {
 	c.PasswordFlag = 1&(options>>6) > 0
 	c.UsernameFlag = 1&(options>>7) > 0
 
-	c.Keepalive, err = decodeUint16(b[n:])
-	if err != nil {
-		return err
-	}
+	c.Keepalive, _ = decodeUint16(b[n:])
 	n += 2
 
-	c.ClientIdentifier, m, err = decodeString(b[n:])
+	if c.ProtocolVersion == MQTT5 {
+		c.Properties, m, err = unpackProperties(c.FixedHeader, b, n, Connect)
+		if err != nil {
+			return err
+		}
+		n += m
+	}
+
+	c.ClientIdentifier, m, err = str(b[n:])
 	if err != nil {
-		return err
+		return malformed(c.FixedHeader, n, "truncated client identifier")
 	}
 	n += m
 
 	if c.WillFlag {
-		c.WillTopic, m, err = decodeString(b[n:])
+		if c.ProtocolVersion == MQTT5 {
+			c.WillProperties, m, err = unpackProperties(c.FixedHeader, b, n, WillProperties)
+			if err != nil {
+				return err
+			}
+			n += m
+		}
+		c.WillTopic, m, err = str(b[n:])
 		if err != nil {
-			return err
+			return malformed(c.FixedHeader, n, "truncated will topic")
 		}
 		n += m
-		c.WillMessage, m, err = decodeBytes(b[n:])
+		c.WillMessage, m, err = bs(b[n:])
 		if err != nil {
-			return err
+			return malformed(c.FixedHeader, n, "truncated will message")
 		}
 		n += m
 	}
 	if c.UsernameFlag {
-		c.Username, m, err = decodeString(b[n:])
+		c.Username, m, err = str(b[n:])
 		if err != nil {
-			return err
+			return malformed(c.FixedHeader, n, "truncated username")
 		}
 		n += m
 	}
 	if c.PasswordFlag {
-		c.Password, _, err = decodeBytes(b[n:])
+		c.Password, _, err = bs(b[n:])
 		if err != nil {
-			return err
+			return malformed(c.FixedHeader, n, "truncated password")
 		}
 	}
 
 	return nil
 }
 
-// Validate performs validation of the fields of a Connect packet
+// Validate performs validation of the fields of a Connect packet, the code
+// returned is the CONNACK return code, or the CONNACK reason code for the
+// protocol level 5
 func (c *ConnectPacket) Validate() byte {
-	if c.PasswordFlag && !c.UsernameFlag {
+	code := c.validate()
+	if c.ProtocolVersion == MQTT5 {
+		return byte(ReasonCodeOf(Connack, code))
+	}
+	return code
+}
+
+func (c *ConnectPacket) validate() byte {
+	if c.PasswordFlag && !c.UsernameFlag && c.ProtocolVersion != MQTT5 {
 		return ErrRefusedBadUsernameOrPassword
 	}
 	if c.ReservedBit != 0 {
 		// Bad reserved bit
 		return ErrProtocolViolation
 	}
-	if (c.ProtocolName == "MQIsdp" && c.ProtocolVersion != 3) || (c.ProtocolName == "MQTT" && c.ProtocolVersion != 4) {
-		// Mismatched or unsupported protocol version
-		return ErrRefusedBadProtocolVersion
-	}
 	if c.ProtocolName != "MQIsdp" && c.ProtocolName != "MQTT" {
 		// Bad protocol name
 		return ErrProtocolViolation
 	}
+	if !supportedVersion(c.ProtocolName, c.ProtocolVersion) {
+		// Mismatched or unsupported protocol version
+		return ErrRefusedBadProtocolVersion
+	}
 	if len(c.ClientIdentifier) > 65535 || len(c.Username) > 65535 || len(c.Password) > 65535 {
 		// Bad size field
 		return ErrProtocolViolation
 	}
-	if len(c.ClientIdentifier) == 0 && !c.CleanSession {
+	if len(c.ClientIdentifier) == 0 && !c.CleanSession && c.ProtocolVersion != MQTT5 {
 		// Bad client identifier
 		return ErrRefusedIDRejected
 	}
+	if c.verifyProperties() != nil {
+		return ErrProtocolViolation
+	}
 
+	if c.WillQoS > 2 {
+		return ErrProtocolViolation
+	}
 	if !c.WillFlag && ((c.WillQoS != byte(0)) || c.WillRetain || len(c.WillTopic) != 0 || len(c.WillMessage) != 0) {
 		return ErrProtocolViolation
 	}
@@ -2,6 +2,7 @@ package packets
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -83,3 +84,52 @@ func TestConnectPacket(t *testing.T) {
 	assert.Equal(t, ll, connectPacketBytes.Cap())
 	packet.Close()
 }
+
+func TestConnectPacketV5(t *testing.T) {
+	receive := uint16(0)
+	cp := NewConnectPacket()
+	cp.ProtocolName = "MQTT"
+	cp.ProtocolVersion = MQTT5
+	cp.PasswordFlag = true
+	cp.Password = []byte("token")
+	assert.Equal(t, byte(Accepted), cp.Validate())
+	assert.NoError(t, cp.Verify())
+
+	cp.Properties = &Properties{ReceiveMaximum: &receive}
+	assert.Equal(t, byte(0x82), cp.Validate())
+	assert.Error(t, cp.Verify())
+
+	cp.Properties = nil
+	cp.PasswordFlag = false
+	cp.ProtocolVersion = 6
+	assert.Equal(t, byte(ErrRefusedBadProtocolVersion), cp.Validate())
+
+	// the protocol name is checked before the level
+	cp.ProtocolName = "FOO"
+	cp.ProtocolVersion = MQTT311
+	assert.Equal(t, byte(ErrProtocolViolation), cp.Validate())
+	var pe *ProtocolError
+	if assert.True(t, errors.As(cp.Verify(), &pe)) {
+		assert.Equal(t, "MQTT-3.1.2-1", pe.Statement)
+	}
+	cp.ProtocolName = "MQTT"
+
+	cp.ProtocolVersion = MQTT311
+	cp.UsernameFlag = true
+	cp.CleanSession = true
+	cp.WillProperties = &Properties{}
+	assert.Equal(t, byte(ErrProtocolViolation), cp.Validate())
+	cp.Close()
+
+	// properties are decoded with the zero-copy frames as well
+	data := []byte{16, 22, 0, 4, 77, 81, 84, 84, 5, 2, 0, 60, 8, 17, 0, 0, 0, 10, 33, 0, 20, 0, 1, 99}
+	d := NewDecoder(bytes.NewReader(data))
+	d.ZeroCopy = true
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	c := packet.(*ConnectPacket)
+	assert.Equal(t, uint32(10), *c.Properties.SessionExpiry)
+	assert.Equal(t, "c", c.ClientIdentifier)
+	assert.Equal(t, MQTT5, d.ProtocolVersion)
+	packet.Close()
+}
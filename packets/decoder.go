@@ -0,0 +1,282 @@
+package packets
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/arthurkiller/mqtgo/topic"
+)
+
+// maxRetainedBuffer is the largest buffer an Encoder or Parser keeps
+// for reuse. Bigger buffers are dropped after use so one huge packet does
+// not pin its memory for the whole connection.
+const maxRetainedBuffer = 64 << 10
+
+// Decoder reads and decodes MQTT control packets from an input stream.
+// A Decoder owns its header buffer and takes the body buffers from the
+// buffer pool, it is meant to be reused for every packet of one connection.
+// It is not safe for concurrent use.
+type Decoder struct {
+	r   io.Reader
+	hdr [5]byte
+
+	// MaxSize limits the remaining length of a decoded packet,
+	// zero means MaxRemainingLength.
+	MaxSize int
+	// ProtocolVersion is the protocol level of the connection, every
+	// packet is decoded with it. It is set from the first CONNECT packet
+	// decoded with a supported protocol level, a client sets it to the
+	// level of the CONNECT it sent, or shares State with its Encoder.
+	ProtocolVersion byte
+	// State, when set, is the protocol state shared with the other side of
+	// the connection, ProtocolVersion follows it. A second CONNECT is
+	// refused either way.
+	State *ConnState
+	// Strict makes Decode reject packets failed on Verify.
+	Strict bool
+	// UTF8 is the validation level of the MQTT strings decoded,
+	// UTF8CheckSpec by default.
+	UTF8 UTF8Check
+	// Topics applies its topic rules, such as a maximum number of levels,
+	// to the topic names and topic filters decoded. Nil checks nothing,
+	// Strict checks the rules of the specification.
+	Topics *topic.Validator
+	// ZeroCopy makes PUBLISH and CONNECT packets decoded into a pooled frame
+	// buffer owned by the packet. Their TopicName, Payload and the CONNECT
+	// strings and bytes fields alias that buffer, which is released by Close,
+	// so they must not be used once the packet is closed.
+	ZeroCopy bool
+	// StreamThreshold makes Decode return PUBLISH packets with a remaining
+	// length over it with the payload streamed from the connection, see
+	// DecodePublishStream. MaxSize does not apply to them but to their
+	// properties. Zero disables it.
+	StreamThreshold int
+
+	// stream is the payload reader of the last streamed PUBLISH
+	stream *io.LimitedReader
+	// local is the protocol state when State is nil
+	local ConnState
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, ProtocolVersion: MQTT311, UTF8: UTF8CheckSpec}
+}
+
+// Reset discards the decoder state and makes it read from r, the
+// configuration is kept and the protocol level goes back to MQTT311, State
+// included.
+func (d *Decoder) Reset(r io.Reader) {
+	d.r = r
+	d.stream = nil
+	d.ProtocolVersion = MQTT311
+	d.connState().Reset(MQTT311)
+}
+
+// connState returns the protocol state of the connection, the local one holds
+// ProtocolVersion
+func (d *Decoder) connState() *ConnState {
+	if d.State != nil {
+		return d.State
+	}
+	d.local.version = d.ProtocolVersion
+	return &d.local
+}
+
+// Decode reads the next packet from the input stream.
+func (d *Decoder) Decode() (ControlPacket, error) {
+	fh, err := d.DecodeFixedHeader()
+	if err != nil {
+		return nil, err
+	}
+	if d.StreamThreshold > 0 && fh.MessageType == Publish && fh.RemainingLength > d.StreamThreshold {
+		// a nil *PublishPacket is not a nil ControlPacket
+		p, err := d.DecodePublishStream(fh)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return d.DecodeControlPacket(fh)
+}
+
+// DecodeFixedHeader reads the fixed header of the next packet. The packet
+// body must then be read with DecodeControlPacket.
+func (d *Decoder) DecodeFixedHeader() (*FixedHeader, error) {
+	if d.stream != nil {
+		// skip what is left of the streamed payload
+		if _, err := io.Copy(ioutil.Discard, d.stream); err != nil {
+			return nil, err
+		}
+		d.stream = nil
+	}
+	if _, err := io.ReadFull(d.r, d.hdr[:1]); err != nil {
+		return nil, err
+	}
+	d.ProtocolVersion = d.connState().Version()
+	fh := &FixedHeader{Version: d.ProtocolVersion}
+	if _, err := fh.unpack(d.r, d.hdr[:]); err != nil {
+		return nil, err
+	}
+	if err := fh.checkFlags(); err != nil {
+		return nil, err
+	}
+	if fh.RemainingLength > MaxRemainingLength {
+		return nil, ErrMQTTPacketLimitation
+	}
+	streamed := d.StreamThreshold > 0 && fh.MessageType == Publish && fh.RemainingLength > d.StreamThreshold
+	if d.MaxSize > 0 && fh.RemainingLength > d.MaxSize && !streamed {
+		return nil, ErrReadPacketLimitation
+	}
+	return fh, nil
+}
+
+// DecodeControlPacket reads the body described by fh and decodes it
+// into a control packet.
+func (d *Decoder) DecodeControlPacket(fh *FixedHeader) (ControlPacket, error) {
+	cp := NewControlPacketWithHeader(fh)
+	if cp == nil {
+		return nil, unknownType(fh)
+	}
+
+	if fu, ok := cp.(frameUnpacker); ok && d.ZeroCopy {
+		f := getFrame(fh.RemainingLength)
+		if _, err := io.ReadFull(d.r, f.b); err != nil {
+			putFrame(f)
+			cp.Close()
+			return nil, err
+		}
+		// from now on the frame is released by cp.Close
+		if err := fu.unpackFrame(f.b, f); err != nil {
+			cp.Close()
+			return nil, err
+		}
+		return d.verify(cp)
+	}
+
+	rb := getBuf(fh.RemainingLength)
+	defer putBuf(rb)
+
+	if _, err := io.ReadFull(d.r, rb); err != nil {
+		cp.Close()
+		return nil, err
+	}
+	if err := cp.Unpack(rb); err != nil {
+		cp.Close()
+		return nil, err
+	}
+	return d.verify(cp)
+}
+
+// verify checks the decoded packet in strict mode and records the protocol
+// version from the first CONNECT
+func (d *Decoder) verify(cp ControlPacket) (ControlPacket, error) {
+	if err := verifyStrings(cp, d.UTF8); err != nil {
+		cp.Close()
+		return nil, err
+	}
+	if err := verifyTopics(cp, d.Topics); err != nil {
+		cp.Close()
+		return nil, err
+	}
+	if d.Strict {
+		if err := cp.Verify(); err != nil {
+			cp.Close()
+			return nil, err
+		}
+	}
+
+	if c, ok := cp.(*ConnectPacket); ok {
+		var err error
+		if d.ProtocolVersion, err = d.connState().connect(c); err != nil {
+			cp.Close()
+			return nil, err
+		}
+	}
+	return cp, nil
+}
+
+// DecodePublishStream reads the variable header of the PUBLISH described by
+// fh and returns the packet with its payload left on the connection. The
+// payload is read through PayloadReader, which is bounded to PayloadLength
+// bytes and valid until the next packet is decoded; the unread part of it is
+// skipped then.
+func (d *Decoder) DecodePublishStream(fh *FixedHeader) (*PublishPacket, error) {
+	if fh.MessageType != Publish {
+		return nil, fmt.Errorf("%w: %s header to DecodePublishStream", ErrInternal, PacketNames[fh.MessageType&0x0F])
+	}
+	// topic length, topic and the MessageID of QoS > 0
+	if _, err := io.ReadFull(d.r, d.hdr[:2]); err != nil {
+		return nil, err
+	}
+	tl, _ := decodeUint16(d.hdr[:2])
+	vl := 2 + int(tl)
+	if vl > fh.RemainingLength {
+		return nil, malformed(fh, 0, "truncated topic name")
+	}
+	if fh.QoS > 0 {
+		if vl += 2; vl > fh.RemainingLength {
+			return nil, malformed(fh, vl-2, "truncated packet identifier")
+		}
+	}
+	vh := getBuf(vl)
+	defer putBuf(vh)
+	copy(vh, d.hdr[:2])
+	if _, err := io.ReadFull(d.r, vh[2:]); err != nil {
+		return nil, err
+	}
+
+	var props *Properties
+	if fh.version() == MQTT5 {
+		var err error
+		if props, vl, err = d.decodeStreamProperties(fh, vh); err != nil {
+			return nil, err
+		}
+	}
+
+	p := NewPublishPacket()
+	p.SetFixedHeader(fh)
+	p.TopicName = string(vh[2 : 2+tl])
+	if fh.QoS > 0 {
+		p.MessageID, _ = decodeUint16(vh[2+tl:])
+	}
+	p.Properties = props
+	d.stream = &io.LimitedReader{R: d.r, N: int64(fh.RemainingLength - vl)}
+	p.PayloadReader = d.stream
+	p.PayloadLength = fh.RemainingLength - vl
+	if _, err := d.verify(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// decodeStreamProperties reads the MQTT 5 properties following the variable
+// header vh of a streamed PUBLISH, and returns them with the length of the
+// variable header properties included
+func (d *Decoder) decodeStreamProperties(fh *FixedHeader, vh []byte) (*Properties, int, error) {
+	l, m, err := decodeLength(d.r, d.hdr[:4])
+	if err == ErrVarintOverflow || err == ErrVarintNotMinimal {
+		return nil, 0, malformed(fh, len(vh)+m-1, "bad properties length")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// the payload is streamed, but the properties are read into memory
+	if d.MaxSize > 0 && l > d.MaxSize {
+		return nil, 0, malformed(fh, len(vh), "properties longer than the size limit")
+	}
+	vl := len(vh) + m + l
+	if vl > fh.RemainingLength {
+		return nil, 0, malformed(fh, len(vh), "properties longer than the packet")
+	}
+	b := getBuf(vl)
+	defer putBuf(b)
+	copy(b, vh)
+	copy(b[len(vh):], d.hdr[:m])
+	if _, err := io.ReadFull(d.r, b[len(vh)+m:]); err != nil {
+		return nil, 0, err
+	}
+	props, _, err := unpackProperties(fh, b, len(vh), Publish)
+	return props, vl, err
+}
@@ -0,0 +1,179 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoderDecode(t *testing.T) {
+	stream := bytes.NewBuffer([]byte{16, 52, 0, 4, 77, 81, 84, 84, 4, 204, 0, 0, 0, 0, 0, 4, 116,
+		101, 115, 116, 0, 12, 84, 101, 115, 116, 32, 80, 97, 121, 108, 111, 97, 100, 0, 8, 116, 101, 115, 116,
+		117, 115, 101, 114, 0, 8, 116, 101, 115, 116, 112, 97, 115, 115})
+	stream.Write([]byte{144, 6, 4, 210, 0, 1, 2, 128})
+	stream.Write([]byte{192, 0})
+
+	d := NewDecoder(stream)
+	d.ProtocolVersion = 3
+
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	cp := packet.(*ConnectPacket)
+	assert.Equal(t, byte(4), d.ProtocolVersion)
+	assert.Equal(t, "testuser", cp.Username)
+
+	packet, err = d.Decode()
+	assert.NoError(t, err)
+	sa := packet.(*SubackPacket)
+	assert.Equal(t, uint16(1234), sa.MessageID)
+	assert.Equal(t, []byte{0, 1, 2, 128}, sa.ReturnCodes)
+	// the body buffer was reused, decoded fields must not alias it
+	assert.Equal(t, []byte("testpass"), cp.Password)
+	assert.Equal(t, []byte("Test Payload"), cp.WillMessage)
+
+	packet, err = d.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(Pingreq), packet.Type())
+
+	cp.Close()
+	sa.Close()
+	packet.Close()
+}
+
+func TestDecoderLimitation(t *testing.T) {
+	d := NewDecoder(bytes.NewBuffer([]byte{144, 6, 4, 210, 0, 1, 2, 128}))
+	d.MaxSize = 4
+	_, err := d.Decode()
+	assert.Equal(t, ErrReadPacketLimitation, err)
+
+	d = NewDecoder(bytes.NewBuffer([]byte{240, 0}))
+	_, err = d.Decode()
+	assert.True(t, errors.Is(err, ErrUnknownPacketType))
+}
+
+func TestDecoderFixedHeader(t *testing.T) {
+	d := NewDecoder(bytes.NewBuffer([]byte{98, 2, 4, 210}))
+	fh, err := d.DecodeFixedHeader()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(Pubrel), fh.MessageType)
+	assert.Equal(t, byte(1), fh.QoS)
+	assert.Equal(t, 2, fh.RemainingLength)
+
+	packet, err := d.DecodeControlPacket(fh)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(1234), packet.(*PubrelPacket).MessageID)
+	packet.Close()
+}
+
+func TestDecoderZeroCopy(t *testing.T) {
+	stream := bytes.NewBuffer([]byte{50, 9, 0, 3, 97, 47, 98, 4, 210, 104, 105})
+	stream.Write([]byte{64, 2, 4, 210})
+
+	d := NewDecoder(stream)
+	d.ZeroCopy = true
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	p := packet.(*PublishPacket)
+	assert.NotNil(t, p.frame)
+	assert.Equal(t, "a/b", p.TopicName)
+	assert.Equal(t, []byte("hi"), p.Payload)
+	// the payload aliases the frame buffer
+	assert.Equal(t, &p.frame.b[len(p.frame.b)-2], &p.Payload[0])
+
+	c := p.Copy()
+	packet.Close()
+	assert.Nil(t, p.frame)
+	assert.Equal(t, "a/b", c.TopicName)
+	assert.Equal(t, []byte("hi"), c.Payload)
+	c.Close()
+
+	packet, err = d.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(1234), packet.(*PubackPacket).MessageID)
+	packet.Close()
+}
+
+func TestDecoderProtocolVersion(t *testing.T) {
+	// MQIsdp CONNECT, PUBLISH, CONNECT of protocol level 5, PINGREQ
+	stream := bytes.NewBuffer([]byte{16, 17, 0, 6, 77, 81, 73, 115, 100, 112, 3, 2, 0, 10, 0, 3, 97, 98, 99})
+	stream.Write([]byte{48, 7, 0, 3, 97, 47, 98, 104, 105})
+	stream.Write([]byte{16, 13, 0, 4, 77, 81, 84, 84, 5, 2, 0, 60, 0, 0, 0})
+	stream.Write([]byte{192, 0})
+
+	d := NewDecoder(stream)
+	assert.Equal(t, MQTT311, d.ProtocolVersion)
+	var packets []ControlPacket
+	for i := 0; i < 4; i++ {
+		packet, err := d.Decode()
+		if i == 2 {
+			// the protocol level is locked by the first CONNECT
+			var perr *ProtocolError
+			assert.True(t, errors.As(err, &perr))
+			assert.Equal(t, "MQTT-3.1.0-2", perr.Statement)
+		} else {
+			assert.NoError(t, err)
+			packets = append(packets, packet)
+		}
+		assert.Equal(t, MQTT31, d.ProtocolVersion)
+	}
+	assert.Equal(t, MQTT31, packets[1].(*PublishPacket).Version)
+	assert.Equal(t, MQTT31, packets[2].(*PingreqPacket).Version)
+	for _, packet := range packets {
+		packet.Close()
+	}
+
+	d.Reset(stream)
+	assert.Equal(t, MQTT311, d.ProtocolVersion)
+}
+
+func TestConnState(t *testing.T) {
+	var conn bytes.Buffer
+	st := NewConnState(MQTT311)
+	d := NewDecoder(&conn)
+	d.State = st
+	e := NewEncoder(&conn)
+	e.State = st
+
+	// a server decodes a CONNECT of protocol level 5, its CONNACK follows it
+	c := NewConnectPacket()
+	c.ProtocolName = "MQTT"
+	c.ProtocolVersion = MQTT5
+	c.CleanSession = true
+	assert.NoError(t, NewEncoder(&conn).Encode(c))
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	packet.Close()
+	assert.Equal(t, MQTT5, st.Version())
+	assert.True(t, st.Connected())
+
+	ca := NewConnackPacket()
+	ca.Properties = &Properties{ReasonString: "welcome"}
+	assert.NoError(t, e.Encode(ca))
+	assert.Equal(t, MQTT5, e.ProtocolVersion)
+	assert.Equal(t, MQTT5, ca.Version)
+	ca.Close()
+	packet, err = d.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "welcome", packet.(*ConnackPacket).Properties.ReasonString)
+	packet.Close()
+
+	// no second CONNECT either way
+	c.ProtocolVersion = MQTT311
+	var perr *ProtocolError
+	assert.True(t, errors.As(e.Encode(c), &perr))
+	assert.Equal(t, "MQTT-3.1.0-2", perr.Statement)
+	assert.Equal(t, MQTT5, st.Version())
+	c.Close()
+
+	p := NewParser(0)
+	p.State = st
+	_, err = p.Feed([]byte{16, 12, 0, 4, 77, 81, 84, 84, 4, 2, 0, 60, 0, 0})
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, MQTT5, p.ProtocolVersion)
+
+	d.Reset(&conn)
+	assert.Equal(t, MQTT311, st.Version())
+	assert.False(t, st.Connected())
+}
@@ -17,6 +17,11 @@ var _disconnectPacketPool = sync.Pool{
 type DisconnectPacket struct {
 	*FixedHeader
 	TraceID string
+
+	// ReasonCode is the reason code of protocol level 5
+	ReasonCode ReasonCode
+	// Properties are the properties of protocol level 5
+	Properties *Properties
 }
 
 // NewDisconnectPacket return the disconnect packet
@@ -30,6 +35,9 @@ func (d *DisconnectPacket) Reset() {
 	d.FixedHeader.QoS = byte(0)
 	d.FixedHeader.RemainingLength = 0
 	d.FixedHeader.Retain = false
+	d.FixedHeader.Version = 0
+	d.ReasonCode = 0
+	d.Properties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
@@ -41,8 +49,24 @@ func (d *DisconnectPacket) Close() {
 // SetTraceID will set traceid for tracing
 func (d *DisconnectPacket) SetTraceID(id string) { d.TraceID = id }
 
-// Verify packet availability
-func (d *DisconnectPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (d *DisconnectPacket) Verify() error {
+	if d.version() != MQTT5 {
+		if d.ReasonCode != 0 {
+			return verifyError(Disconnect, "", "reason code on protocol level %d", d.version())
+		}
+		return verifyPacketProperties(d.FixedHeader, d.Properties)
+	}
+	if !d.ReasonCode.Valid(Disconnect, MQTT5) {
+		return verifyError(Disconnect, "", "unknown reason code 0x%02X", byte(d.ReasonCode))
+	}
+	return verifyPacketProperties(d.FixedHeader, d.Properties)
+}
+
+func (d *DisconnectPacket) verifyStrings(check UTF8Check) error {
+	return d.Properties.verifyStrings(Disconnect, check)
+}
 
 // SetFixedHeader will set fh for our header
 func (d *DisconnectPacket) SetFixedHeader(fh *FixedHeader) {
@@ -56,21 +80,50 @@ func (d *DisconnectPacket) Type() byte {
 
 // String export the packet of disconnect info
 func (d *DisconnectPacket) String() string {
-	return fmt.Sprintf("%s traceID: %s", d.FixedHeader, d.TraceID)
+	return fmt.Sprintf("%s reasoncode: 0x%02X traceID: %s", d.FixedHeader, byte(d.ReasonCode), d.TraceID)
 }
 
 // Write will write the packets mostly into a net.Conn
 func (d *DisconnectPacket) Write(w io.Writer) (int, error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	d.FixedHeader.pack(b.b[:5])
-	return w.Write(b.b[3:5])
+	n, err := d.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (d *DisconnectPacket) WriteTo(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header
+	b := getBuf(5 + d.length())
+	defer putBuf(b)
+	if d.version() == MQTT5 {
+		if err := packReason(b[5:], d.ReasonCode, d.Properties); err != nil {
+			return 0, err
+		}
+	}
+	return writeFrame(w, d.FixedHeader, b)
+}
+
+// length returns the remaining length of the packet, the reason code and
+// properties are only carried on protocol level 5
+func (d *DisconnectPacket) length() int {
+	if d.version() != MQTT5 {
+		return 0
+	}
+	return reasonLength(d.ReasonCode, d.Properties)
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (d *DisconnectPacket) Size() int {
+	return packetSize(d.length())
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
 // header has been read
-func (d *DisconnectPacket) Unpack([]byte) error {
-	return nil
+func (d *DisconnectPacket) Unpack(b []byte) (err error) {
+	if d.version() != MQTT5 {
+		return nil
+	}
+	d.ReasonCode, d.Properties, err = unpackReason(d.FixedHeader, b, 0)
+	return err
 }
 
 // Details returns a Details struct containing the QoS and
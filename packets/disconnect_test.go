@@ -0,0 +1,47 @@
+package packets
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisconnectPacketV5(t *testing.T) {
+	cp := NewDisconnectPacket()
+	cp.SetVersion(MQTT5)
+	assert.Equal(t, 2, cp.Size())
+	cp.ReasonCode = 0x8E
+	assert.Equal(t, 3, cp.Size())
+	cp.Properties = &Properties{ReasonString: "taken over"}
+	assert.NoError(t, cp.Verify())
+	cp.ReasonCode = 0x01
+	assert.Error(t, cp.Verify())
+	cp.ReasonCode = 0x8E
+	cp.Properties.AssignedClientID = "c"
+	assert.Error(t, cp.Verify())
+	cp.Properties.AssignedClientID = ""
+
+	b, err := Marshal(cp)
+	assert.NoError(t, err)
+	assert.Equal(t, cp.Size(), len(b))
+	cp.Close()
+
+	d := NewDecoder(bytes.NewReader(b))
+	d.ProtocolVersion = MQTT5
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	dp := packet.(*DisconnectPacket)
+	assert.Equal(t, ReasonSessionTakenOver, dp.ReasonCode)
+	assert.Equal(t, "taken over", dp.Properties.ReasonString)
+	packet.Close()
+
+	// the 3.1.1 form is left unchanged
+	cp = NewDisconnectPacket()
+	b, err = Marshal(cp)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{224, 0}, b)
+	cp.ReasonCode = 0x8E
+	assert.Error(t, cp.Verify())
+	cp.Close()
+}
@@ -0,0 +1,111 @@
+package packets
+
+import (
+	"bytes"
+	"io"
+)
+
+// Encoder encodes MQTT control packets and writes them to an output stream.
+// Every packet is built in a buffer owned by the Encoder and sent with a
+// single Write. It is not safe for concurrent use.
+type Encoder struct {
+	w   io.Writer
+	hdr [5]byte
+	buf bytes.Buffer
+
+	// ProtocolVersion is the protocol level of the connection, every
+	// packet is encoded with it. It is set from the first CONNECT packet
+	// encoded with a supported protocol level, a server sets it to the
+	// level of the CONNECT it received, or shares State with its Decoder.
+	ProtocolVersion byte
+	// State, when set, is the protocol state shared with the other side of
+	// the connection, ProtocolVersion follows it. A second CONNECT is
+	// refused either way.
+	State *ConnState
+	// Strict makes Encode refuse packets failed on Verify.
+	Strict bool
+	// UTF8 is the validation level of the MQTT strings encoded,
+	// UTF8CheckSpec by default.
+	UTF8 UTF8Check
+
+	// local is the protocol state when State is nil
+	local ConnState
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, ProtocolVersion: MQTT311, UTF8: UTF8CheckSpec}
+}
+
+// Reset makes the encoder write to w, the configuration is kept and the
+// protocol level goes back to MQTT311, State included.
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.ProtocolVersion = MQTT311
+	e.connState().Reset(MQTT311)
+}
+
+// connState returns the protocol state of the connection, the local one holds
+// ProtocolVersion
+func (e *Encoder) connState() *ConnState {
+	if e.State != nil {
+		return e.State
+	}
+	e.local.version = e.ProtocolVersion
+	return &e.local
+}
+
+// Encode writes the encoding of cp to the stream.
+func (e *Encoder) Encode(cp ControlPacket) error {
+	st := e.connState()
+	e.ProtocolVersion = st.Version()
+	version := e.ProtocolVersion
+	c, connect := cp.(*ConnectPacket)
+	if connect && supportedVersion(c.ProtocolName, c.ProtocolVersion) {
+		version = c.ProtocolVersion
+	}
+	// the packet is checked at the protocol level it is encoded with
+	cp.SetVersion(version)
+	if err := verifyStrings(cp, e.UTF8); err != nil {
+		return refused(err)
+	}
+	if e.Strict {
+		if err := cp.Verify(); err != nil {
+			return refused(err)
+		}
+	}
+	if connect {
+		var err error
+		if e.ProtocolVersion, err = st.connect(c); err != nil {
+			return refused(err)
+		}
+	}
+
+	if p, ok := cp.(*PublishPacket); ok && p.PayloadReader != nil {
+		// never buffer a streamed payload
+		_, err := p.WriteTo(e.w)
+		return err
+	}
+
+	e.buf.Reset()
+	defer func() {
+		if e.buf.Cap() > maxRetainedBuffer {
+			e.buf = bytes.Buffer{}
+		}
+	}()
+	if _, err := cp.Write(&e.buf); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.buf.Bytes())
+	return err
+}
+
+// EncodeFixedHeader writes the encoding of fh alone to the stream.
+func (e *Encoder) EncodeFixedHeader(fh *FixedHeader) error {
+	n := fh.pack(e.hdr[:])
+	if n == 0 {
+		return ErrVarintOverflow
+	}
+	_, err := e.w.Write(e.hdr[5-n:])
+	return err
+}
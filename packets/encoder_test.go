@@ -0,0 +1,107 @@
+package packets
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countWriter counts the Write calls it received
+type countWriter struct {
+	bytes.Buffer
+	calls int
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	w.calls++
+	return w.Buffer.Write(b)
+}
+
+func TestEncoderEncode(t *testing.T) {
+	w := &countWriter{}
+	e := NewEncoder(w)
+
+	cp := NewPublishPacket()
+	cp.FixedHeader.QoS = 1
+	cp.TopicName = "a/b"
+	cp.MessageID = 1234
+	cp.Payload = []byte("hi")
+	assert.NoError(t, e.Encode(cp))
+	assert.Equal(t, 1, w.calls)
+	assert.Equal(t, []byte{50, 9, 0, 3, 97, 47, 98, 4, 210, 104, 105}, w.Bytes())
+	cp.Close()
+
+	w.Reset()
+	c := NewConnectPacket()
+	c.ProtocolName = "MQIsdp"
+	c.ProtocolVersion = 3
+	c.CleanSession = true
+	c.ClientIdentifier = "test"
+	assert.NoError(t, e.Encode(c))
+	assert.Equal(t, 2, w.calls)
+	assert.Equal(t, MQTT31, e.ProtocolVersion)
+
+	packet, err := NewDecoder(&w.Buffer).Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, c.String(), packet.String())
+	c.Close()
+	packet.Close()
+}
+
+func TestEncoderFixedHeader(t *testing.T) {
+	w := &bytes.Buffer{}
+	e := NewEncoder(w)
+	assert.NoError(t, e.EncodeFixedHeader(&FixedHeader{MessageType: Publish, QoS: 1, RemainingLength: 321}))
+	assert.Equal(t, []byte{50, 193, 2}, w.Bytes())
+}
+
+func TestEncoderProtocolVersion(t *testing.T) {
+	e := NewEncoder(&bytes.Buffer{})
+	c := NewConnectPacket()
+	c.ProtocolName = "MQIsdp"
+	c.ProtocolVersion = MQTT31
+	c.CleanSession = true
+	assert.NoError(t, e.Encode(c))
+	assert.Equal(t, MQTT31, e.ProtocolVersion)
+	assert.Equal(t, MQTT31, c.Version)
+	c.Close()
+
+	p := NewPublishPacket()
+	p.TopicName = "a/b"
+	assert.NoError(t, e.Encode(p))
+	assert.Equal(t, MQTT31, p.Version)
+	p.Close()
+
+	e.Reset(&bytes.Buffer{})
+	assert.Equal(t, MQTT311, e.ProtocolVersion)
+}
+
+func TestEncoderStrictV5(t *testing.T) {
+	w := &bytes.Buffer{}
+	e := NewEncoder(w)
+	e.ProtocolVersion = MQTT5
+	e.Strict = true
+
+	p := NewPublishPacket()
+	p.TopicName = "a/b"
+	p.Properties = &Properties{ContentType: "text/plain"}
+	assert.NoError(t, e.Encode(p))
+	p.Close()
+
+	d := NewDisconnectPacket()
+	d.ReasonCode = ReasonDisconnectWithWill
+	d.Properties = &Properties{ReasonString: "bye"}
+	assert.NoError(t, e.Encode(d))
+	d.Close()
+
+	// a failed CONNECT does not change the protocol level
+	e.ProtocolVersion = MQTT311
+	c := NewConnectPacket()
+	c.ProtocolName = "MQTT"
+	c.ProtocolVersion = MQTT5
+	c.ReservedBit = 1
+	assert.Error(t, e.Encode(c))
+	assert.Equal(t, MQTT311, e.ProtocolVersion)
+	c.Close()
+}
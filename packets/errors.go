@@ -0,0 +1,153 @@
+package packets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Below are the categories of ProtocolError, test them with errors.Is
+var (
+	// ErrMalformedPacket is the category of packets which can not be decoded
+	ErrMalformedPacket = errors.New("malformed packet")
+	// ErrSpecViolation is the category of packets decoded but breaking a
+	// normative statement of the specification
+	ErrSpecViolation = errors.New("protocol violation")
+	// ErrUnknownPacketType is the category of packets with a reserved type
+	ErrUnknownPacketType = errors.New("unknown packet type")
+	// ErrBadUTF8 is the category of packets carrying a string which is not
+	// well-formed UTF-8 or holds a forbidden code point
+	ErrBadUTF8 = errors.New("bad UTF-8 string")
+	// ErrBadFlags is the category of fixed headers carrying flags not
+	// allowed for their packet type
+	ErrBadFlags = errors.New("bad fixed header flags")
+)
+
+// ProtocolError describes a packet breaking the MQTT specification
+type ProtocolError struct {
+	// PacketType is the type of the packet in error
+	PacketType byte
+	// Offset is the offset of the faulty byte from the head of the packet,
+	// -1 for the errors not bound to a byte, such as the ones of Verify
+	Offset int
+	// Statement is the normative statement violated, such as MQTT-3.8.3-3,
+	// it may be empty
+	Statement string
+	// Reason describes the violation
+	Reason string
+	// Close reports whether the connection must be closed on the error. It
+	// is set for the packets received breaking the specification, not for
+	// the packets an Encoder refuses, which were not sent, nor for the
+	// topics refused by the stricter rules of a Decoder or a Parser, which a
+	// server of protocol level 5 may refuse with a reason code instead.
+	Close bool
+	// Err is the category of the error
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	s := PacketNames[e.PacketType&0x0F] + ": " + e.Reason
+	if e.Offset >= 0 {
+		s += fmt.Sprintf(" at byte %d", e.Offset)
+	}
+	if e.Statement != "" {
+		s += " [" + e.Statement + "]"
+	}
+	return s
+}
+
+// Unwrap returns the category of the error
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
+// ReasonCode returns the reason code of protocol level 5 the DISCONNECT, or
+// the CONNACK, closing the connection on the error carries: Protocol Error
+// for the breaches of a normative statement, Malformed Packet otherwise
+func (e *ProtocolError) ReasonCode() ReasonCode {
+	if e.Err == ErrSpecViolation {
+		return ReasonProtocolError
+	}
+	return ReasonMalformedPacket
+}
+
+// refused marks err, the ProtocolError of a packet refused by a local policy
+// or not sent, as leaving the connection open
+func refused(err error) error {
+	if perr, ok := err.(*ProtocolError); ok {
+		perr.Close = false
+	}
+	return err
+}
+
+// malformed returns the ProtocolError of a packet body which can not be
+// decoded, offset is counted from the head of the body
+func malformed(fh *FixedHeader, offset int, reason string) error {
+	return &ProtocolError{
+		PacketType: fh.MessageType,
+		Offset:     packetSize(fh.RemainingLength) - fh.RemainingLength + offset,
+		Reason:     reason,
+		Close:      true,
+		Err:        ErrMalformedPacket,
+	}
+}
+
+// unknownType returns the ProtocolError of a fixed header carrying a reserved
+// packet type
+func unknownType(fh *FixedHeader) error {
+	return &ProtocolError{
+		PacketType: fh.MessageType,
+		Offset:     0,
+		Reason:     "reserved packet type",
+		Close:      true,
+		Err:        ErrUnknownPacketType,
+	}
+}
+
+// flagsError returns the ProtocolError of a fixed header failed on checkFlags
+func flagsError(fh *FixedHeader, statement string, format string, a ...interface{}) error {
+	return &ProtocolError{
+		PacketType: fh.MessageType,
+		Offset:     0,
+		Statement:  statement,
+		Reason:     fmt.Sprintf(format, a...),
+		Close:      true,
+		Err:        ErrBadFlags,
+	}
+}
+
+// stringError returns the ProtocolError of a string failed on UTF-8 validation
+func stringError(packetType byte, statement string, format string, a ...interface{}) error {
+	return &ProtocolError{
+		PacketType: packetType,
+		Offset:     -1,
+		Statement:  statement,
+		Reason:     fmt.Sprintf(format, a...),
+		Close:      true,
+		Err:        ErrBadUTF8,
+	}
+}
+
+// verifyError returns the error of a packet failed on Verify
+func verifyError(packetType byte, statement string, format string, a ...interface{}) error {
+	return &ProtocolError{
+		PacketType: packetType,
+		Offset:     -1,
+		Statement:  statement,
+		Reason:     fmt.Sprintf(format, a...),
+		Close:      true,
+		Err:        ErrSpecViolation,
+	}
+}
+
+// propertyError returns the ProtocolError of MQTT 5 properties which can not
+// be decoded, offset is counted from the head of the properties
+func propertyError(packetType byte, offset int, category error, format string, a ...interface{}) error {
+	if packetType == WillProperties {
+		packetType = Connect
+	}
+	return &ProtocolError{
+		PacketType: packetType,
+		Offset:     offset,
+		Reason:     fmt.Sprintf(format, a...),
+		Close:      true,
+		Err:        category,
+	}
+}
@@ -0,0 +1,114 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/arthurkiller/mqtgo/topic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtocolError(t *testing.T) {
+	// PUBLISH QoS 1 whose remaining length ends in the packet identifier
+	_, _, err := ReadPacket(bytes.NewBuffer([]byte{50, 6, 0, 3, 97, 47, 98, 4}))
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+	var perr *ProtocolError
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, byte(Publish), perr.PacketType)
+	assert.Equal(t, 7, perr.Offset)
+	assert.True(t, perr.Close)
+	assert.Equal(t, "PUBLISH: truncated packet identifier at byte 7", err.Error())
+
+	// SUBSCRIBE topic filter longer than the packet
+	_, _, err = ReadPacket(bytes.NewBuffer([]byte{130, 5, 0, 1, 0, 9, 97}))
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, 4, perr.Offset)
+
+	_, _, err = ReadPacket(bytes.NewBuffer([]byte{0, 0}))
+	assert.True(t, errors.Is(err, ErrUnknownPacketType))
+	assert.Equal(t, "Reserved: reserved packet type at byte 0", err.Error())
+
+	p := NewPublishPacket()
+	p.TopicName = "a/#"
+	err = p.Verify()
+	assert.True(t, errors.Is(err, ErrSpecViolation))
+	assert.False(t, errors.Is(err, ErrMalformedPacket))
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, "MQTT-3.3.2-2", perr.Statement)
+	assert.Equal(t, -1, perr.Offset)
+	p.Close()
+}
+
+func TestProtocolErrorReasonCode(t *testing.T) {
+	var perr *ProtocolError
+	_, _, err := ReadPacket(bytes.NewBuffer([]byte{50, 6, 0, 3, 97, 47, 98, 4}))
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, ReasonMalformedPacket, perr.ReasonCode())
+
+	p := NewPublishPacket()
+	p.TopicName = "a/#"
+	assert.True(t, errors.As(p.Verify(), &perr))
+	assert.Equal(t, ReasonProtocolError, perr.ReasonCode())
+	p.Close()
+}
+
+func TestProtocolErrorClose(t *testing.T) {
+	var perr *ProtocolError
+	// SUBSCRIBE with QoS 3, decoded then encoded
+	d := NewDecoder(bytes.NewBuffer([]byte{130, 6, 0, 1, 0, 1, 97, 3}))
+	d.Strict = true
+	_, err := d.Decode()
+	assert.True(t, errors.As(err, &perr))
+	assert.True(t, perr.Close)
+
+	s := NewSubscribePacket()
+	s.MessageID = 1
+	s.Topics, s.QoSs = []string{"a"}, []byte{3}
+	e := NewEncoder(&bytes.Buffer{})
+	e.Strict = true
+	assert.True(t, errors.As(e.Encode(s), &perr))
+	assert.False(t, perr.Close)
+	s.Close()
+
+	// a topic breaking the limits of the decoder only
+	d = NewDecoder(bytes.NewBuffer([]byte{48, 7, 0, 5, 97, 47, 98, 47, 99}))
+	d.Topics = &topic.Validator{MaxLevels: 2}
+	_, err = d.Decode()
+	assert.True(t, errors.As(err, &perr))
+	assert.False(t, perr.Close)
+	// a wildcard in a topic name
+	d = NewDecoder(bytes.NewBuffer([]byte{48, 5, 0, 3, 97, 47, 35}))
+	d.Topics = &topic.Validator{MaxLevels: 2}
+	_, err = d.Decode()
+	assert.True(t, errors.As(err, &perr))
+	assert.True(t, perr.Close)
+}
+
+func TestDecodePublishStreamErrors(t *testing.T) {
+	for name, in := range map[string][]byte{
+		// topic length 5 in a remaining length of 4
+		"topic": {48, 4, 0, 5, 97, 98},
+		// QoS 1 without room for the packet identifier
+		"packet identifier": {50, 3, 0, 1, 97},
+	} {
+		d := NewDecoder(bytes.NewBuffer(in))
+		d.StreamThreshold = 1
+		cp, err := d.Decode()
+		assert.True(t, errors.Is(err, ErrMalformedPacket), name)
+		assert.True(t, cp == nil, name)
+	}
+
+	// properties longer than the packet
+	d := NewDecoder(bytes.NewBuffer([]byte{48, 5, 0, 1, 97, 9, 0}))
+	d.ProtocolVersion = MQTT5
+	d.StreamThreshold = 1
+	cp, err := d.Decode()
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+	assert.True(t, cp == nil)
+
+	d = NewDecoder(&bytes.Buffer{})
+	_, err = d.DecodePublishStream(&FixedHeader{MessageType: Subscribe})
+	assert.True(t, errors.Is(err, ErrInternal))
+}
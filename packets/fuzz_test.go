@@ -0,0 +1,238 @@
+package packets
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// fuzzSeeds are well formed packets of every type, the checked-in corpus
+// under testdata/fuzz holds the hostile inputs found so far
+var fuzzSeeds = [][]byte{
+	// CONNECT with will, username and password
+	{16, 52, 0, 4, 77, 81, 84, 84, 4, 204, 0, 0, 0, 0, 0, 4, 116,
+		101, 115, 116, 0, 12, 84, 101, 115, 116, 32, 80, 97, 121, 108, 111, 97, 100, 0, 8, 116, 101, 115, 116,
+		117, 115, 101, 114, 0, 8, 116, 101, 115, 116, 112, 97, 115, 115},
+	// CONNECT of protocol level 5 with properties and will properties
+	{16, 31, 0, 4, 77, 81, 84, 84, 5, 6, 0, 60, 8, 17, 0, 0, 0, 10, 33, 0, 20, 0, 1, 99,
+		2, 1, 1, 0, 1, 119, 0, 1, 120},
+	{32, 2, 1, 0},
+	{48, 7, 0, 3, 97, 47, 98, 104, 105},
+	{50, 9, 0, 3, 97, 47, 98, 4, 210, 104, 105},
+	{64, 2, 4, 210},
+	{80, 2, 4, 210},
+	{98, 2, 4, 210},
+	{112, 2, 4, 210},
+	{130, 14, 4, 210, 0, 3, 97, 47, 98, 1, 0, 3, 99, 47, 35, 2},
+	{144, 6, 4, 210, 0, 1, 2, 128},
+	{162, 9, 4, 210, 0, 3, 97, 47, 98, 0, 0},
+	{176, 2, 4, 210},
+	{192, 0},
+	{208, 0},
+	{224, 0},
+}
+
+// addSeeds calls add with the seeds, of protocol level 3.1.1, and with the
+// golden packets at their protocol level
+func addSeeds(add func(data []byte, version byte)) {
+	for _, seed := range fuzzSeeds {
+		add(seed, MQTT311)
+	}
+	for _, g := range goldenPackets {
+		wire, err := hex.DecodeString(strings.ReplaceAll(g.wire, " ", ""))
+		if err != nil {
+			continue
+		}
+		if g.version == 0 {
+			add(wire, MQTT311)
+		} else {
+			add(wire, g.version)
+		}
+	}
+}
+
+// fuzzVersion maps the fuzzed byte v to a supported protocol level
+func fuzzVersion(v byte) byte {
+	switch v {
+	case MQTT31, MQTT5:
+		return v
+	}
+	return MQTT311
+}
+
+// FuzzReadPacket checks ReadPacket never panics, and that a packet it
+// accepts encodes into bytes it decodes back to the same encoding.
+func FuzzReadPacket(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cp, _, err := ReadPacket(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		defer cp.Close()
+		checkRoundTrip(t, cp, MQTT311)
+	})
+}
+
+// FuzzUnpack throws any body at the Unpack of every packet type, with the
+// fixed header taken from the first byte so the flags vary as well, at the
+// protocol level taken from the version byte.
+func FuzzUnpack(f *testing.F) {
+	addSeeds(func(data []byte, version byte) {
+		fh := &FixedHeader{}
+		if n, err := fh.unpackBytes(data); err == nil {
+			f.Add(data[0], data[n:], version)
+		}
+	})
+	f.Fuzz(func(t *testing.T, typ byte, body []byte, version byte) {
+		version = fuzzVersion(version)
+		fh := &FixedHeader{RemainingLength: len(body), Version: version}
+		fh.unpackFlags(typ)
+		cp := NewControlPacketWithHeader(fh)
+		if cp == nil {
+			return
+		}
+		defer cp.Close()
+		if err := cp.Unpack(body); err != nil {
+			return
+		}
+		cp.Verify()
+		if fh.checkFlags() == nil {
+			checkRoundTrip(t, cp, version)
+		}
+	})
+}
+
+// FuzzDecoders checks the decoding entry points agree on any input: the
+// copying and the zero-copy Unmarshal on protocol level 3.1.1, the copying
+// and the zero-copy Decoder and a Parser fed byte by byte at the protocol
+// level taken from the version byte.
+func FuzzDecoders(f *testing.F) {
+	addSeeds(func(data []byte, version byte) {
+		f.Add(data, version)
+	})
+	f.Fuzz(func(t *testing.T, data []byte, version byte) {
+		version = fuzzVersion(version)
+		if version == MQTT311 {
+			want, wn, werr := Unmarshal(data)
+			got, n, err := UnmarshalNoCopy(data)
+			if (err == nil) != (werr == nil) || n != wn {
+				t.Fatalf("UnmarshalNoCopy: %d %v, Unmarshal: %d %v", n, err, wn, werr)
+			}
+			if err == nil {
+				sameEncoding(t, want, got)
+				want.Close()
+				got.Close()
+			}
+		}
+
+		// the input bounds the body buffers of the decoders
+		d := NewDecoder(bytes.NewReader(data))
+		d.ProtocolVersion = version
+		d.MaxSize = len(data)
+		want, werr := d.Decode()
+		if werr == nil {
+			defer want.Close()
+		}
+
+		zd := NewDecoder(bytes.NewReader(data))
+		zd.ProtocolVersion = version
+		zd.MaxSize = len(data)
+		zd.ZeroCopy = true
+		got, err := zd.Decode()
+		if (err == nil) != (werr == nil) {
+			t.Fatalf("zero-copy Decoder: %v, Decoder: %v", err, werr)
+		}
+		if err == nil {
+			sameEncoding(t, want, got)
+			got.Close()
+		}
+
+		p := NewParser(0)
+		p.ProtocolVersion = version
+		var parsed []ControlPacket
+		for i := range data {
+			packets, err := p.Feed(data[i : i+1])
+			parsed = append(parsed, packets...)
+			if err != nil {
+				break
+			}
+		}
+		if werr == nil {
+			if len(parsed) == 0 {
+				t.Fatalf("Parser missed the packet the Decoder decoded")
+			}
+			sameEncoding(t, want, parsed[0])
+		}
+		for _, cp := range parsed {
+			cp.Close()
+		}
+	})
+}
+
+// FuzzProperties checks the MQTT 5 properties accepted by Unpack encode into
+// bytes decoding back to the same encoding.
+func FuzzProperties(f *testing.F) {
+	f.Add([]byte{7, 0x01, 1, 0x23, 0, 3, 0x0B, 1}, byte(Publish))
+	f.Add([]byte{8, 0x26, 0, 1, 97, 0, 1, 98, 0x1F}, byte(Puback))
+	f.Fuzz(func(t *testing.T, data []byte, packetType byte) {
+		p := &Properties{}
+		if _, err := p.Unpack(data, packetType); err != nil {
+			return
+		}
+		p.Verify(packetType)
+		b := make([]byte, p.Size())
+		n, err := p.Pack(b)
+		if err != nil {
+			return
+		}
+		if n != len(b) {
+			t.Fatalf("Size %d, packed %d bytes", len(b), n)
+		}
+		again := &Properties{}
+		if _, err := again.Unpack(b, packetType); err != nil {
+			t.Fatalf("decode %v: %v", b, err)
+		}
+		ab := make([]byte, again.Size())
+		again.Pack(ab)
+		if !bytes.Equal(b, ab) {
+			t.Fatalf("encodings differ: %v, %v", b, ab)
+		}
+	})
+}
+
+// checkRoundTrip encodes cp, decodes the encoding at the protocol level
+// version and checks it encodes to the same bytes again
+func checkRoundTrip(t *testing.T, cp ControlPacket, version byte) {
+	b, err := Marshal(cp)
+	if err != nil {
+		return
+	}
+	if len(b) != cp.Size() {
+		t.Fatalf("Size %d, encoded %d bytes", cp.Size(), len(b))
+	}
+	r := bytes.NewReader(b)
+	d := NewDecoder(r)
+	d.ProtocolVersion = version
+	again, err := d.Decode()
+	if err != nil {
+		t.Fatalf("decode %v of %s: %v", b, cp, err)
+	}
+	defer again.Close()
+	if r.Len() != 0 {
+		t.Fatalf("decoded %d bytes of %d", len(b)-r.Len(), len(b))
+	}
+	sameEncoding(t, cp, again)
+}
+
+// sameEncoding fails t if a and b do not encode to the same bytes
+func sameEncoding(t *testing.T, a, b ControlPacket) {
+	ab, aerr := Marshal(a)
+	bb, berr := Marshal(b)
+	if (aerr == nil) != (berr == nil) || !bytes.Equal(ab, bb) {
+		t.Fatalf("encodings differ: %v %v, %v %v", ab, aerr, bb, berr)
+	}
+}
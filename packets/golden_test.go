@@ -0,0 +1,571 @@
+package packets
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// goldenPackets are wire formats worked out by hand from the MQTT 3.1, 3.1.1
+// and 5 specifications, not produced by this package. Every one of them must
+// decode into the packet built by its function and that packet must encode
+// back into the very same bytes, so a bug shared by the encoder and the
+// decoder can not go unnoticed. The packets with a version are decoded and
+// encoded on a connection of that protocol level.
+var goldenPackets = []struct {
+	name    string
+	version byte
+	wire    string
+	packet  func() ControlPacket
+}{
+	{
+		name: "CONNECT 3.1.1 empty client identifier",
+		// MQTT, level 4, clean session, keepalive 60, client id ""
+		wire: "10 0c 00 04 4d 51 54 54 04 02 00 3c 00 00",
+		packet: func() ControlPacket {
+			c := NewConnectPacket()
+			c.ProtocolName = "MQTT"
+			c.ProtocolVersion = 4
+			c.CleanSession = true
+			c.Keepalive = 60
+			return c
+		},
+	},
+	{
+		name: "CONNECT 3.1 MQIsdp",
+		// MQIsdp, level 3, clean session, keepalive 10, client id "abc"
+		wire: "10 11 00 06 4d 51 49 73 64 70 03 02 00 0a 00 03 61 62 63",
+		packet: func() ControlPacket {
+			c := NewConnectPacket()
+			c.ProtocolName = "MQIsdp"
+			c.ProtocolVersion = 3
+			c.CleanSession = true
+			c.Keepalive = 10
+			c.ClientIdentifier = "abc"
+			return c
+		},
+	},
+	{
+		name: "CONNECT 3.1.1 will username password",
+		// flags 0xee: username, password, will retain, will QoS 1, will, clean session
+		wire: "10 1e 00 04 4d 51 54 54 04 ee 01 2c 00 02 63 31 00 03 77 2f 74 00 03 62 79 65 00 01 75 00 01 70",
+		packet: func() ControlPacket {
+			c := NewConnectPacket()
+			c.ProtocolName = "MQTT"
+			c.ProtocolVersion = 4
+			c.CleanSession = true
+			c.WillFlag = true
+			c.WillQoS = 1
+			c.WillRetain = true
+			c.UsernameFlag = true
+			c.PasswordFlag = true
+			c.Keepalive = 300
+			c.ClientIdentifier = "c1"
+			c.WillTopic = "w/t"
+			c.WillMessage = []byte("bye")
+			c.Username = "u"
+			c.Password = []byte("p")
+			return c
+		},
+	},
+	{
+		name: "CONNACK session present",
+		wire: "20 02 01 00",
+		packet: func() ControlPacket {
+			ca := NewConnackPacket()
+			ca.SessionPresent = true
+			return ca
+		},
+	},
+	{
+		name: "CONNACK not authorized",
+		wire: "20 02 00 05",
+		packet: func() ControlPacket {
+			ca := NewConnackPacket()
+			ca.ReturnCode = 5
+			return ca
+		},
+	},
+	{
+		name: "PUBLISH QoS 0",
+		wire: "30 07 00 03 61 2f 62 68 69",
+		packet: func() ControlPacket {
+			p := NewPublishPacket()
+			p.TopicName = "a/b"
+			p.Payload = []byte("hi")
+			return p
+		},
+	},
+	{
+		name: "PUBLISH QoS 0 empty payload",
+		wire: "30 05 00 03 61 2f 62",
+		packet: func() ControlPacket {
+			p := NewPublishPacket()
+			p.TopicName = "a/b"
+			return p
+		},
+	},
+	{
+		name: "PUBLISH QoS 1 dup retain",
+		wire: "3b 09 00 03 61 2f 62 00 0a 68 69",
+		packet: func() ControlPacket {
+			p := NewPublishPacket()
+			p.Dup = true
+			p.QoS = 1
+			p.Retain = true
+			p.TopicName = "a/b"
+			p.MessageID = 10
+			p.Payload = []byte("hi")
+			return p
+		},
+	},
+	{
+		name: "PUBLISH QoS 2 two bytes remaining length",
+		// remaining length 205 = 0xcd 0x01, payload of 200 'x'
+		wire: "34 cd 01 00 01 74 ff ff" + strings.Repeat(" 78", 200),
+		packet: func() ControlPacket {
+			p := NewPublishPacket()
+			p.QoS = 2
+			p.TopicName = "t"
+			p.MessageID = 65535
+			p.Payload = bytes.Repeat([]byte("x"), 200)
+			return p
+		},
+	},
+	{
+		name: "PUBACK",
+		wire: "40 02 00 01",
+		packet: func() ControlPacket {
+			pa := NewPubackPacket()
+			pa.MessageID = 1
+			return pa
+		},
+	},
+	{
+		name: "PUBREC",
+		wire: "50 02 ff ff",
+		packet: func() ControlPacket {
+			pr := NewPubrecPacket()
+			pr.MessageID = 65535
+			return pr
+		},
+	},
+	{
+		name: "PUBREL",
+		// fixed header flags 0010 are mandatory
+		wire: "62 02 12 34",
+		packet: func() ControlPacket {
+			pr := NewPubrelPacket()
+			pr.MessageID = 0x1234
+			return pr
+		},
+	},
+	{
+		name: "PUBCOMP",
+		wire: "70 02 12 34",
+		packet: func() ControlPacket {
+			pc := NewPubcompPacket()
+			pc.MessageID = 0x1234
+			return pc
+		},
+	},
+	{
+		name: "SUBSCRIBE multiple topics",
+		wire: "82 14 00 0a 00 03 61 2f 62 00 00 03 63 2f 23 01 00 03 2b 2f 64 02",
+		packet: func() ControlPacket {
+			s := NewSubscribePacket()
+			s.MessageID = 10
+			s.Topics = []string{"a/b", "c/#", "+/d"}
+			s.QoSs = []byte{0, 1, 2}
+			return s
+		},
+	},
+	{
+		name: "SUBACK with failure",
+		wire: "90 05 00 0a 00 01 80",
+		packet: func() ControlPacket {
+			sa := NewSubackPacket()
+			sa.MessageID = 10
+			sa.ReturnCodes = []byte{0, 1, 0x80}
+			return sa
+		},
+	},
+	{
+		name: "UNSUBSCRIBE multiple topics",
+		wire: "a2 0c 00 0b 00 03 61 2f 62 00 03 63 2f 23",
+		packet: func() ControlPacket {
+			u := NewUnsubscribePacket()
+			u.MessageID = 11
+			u.Topics = []string{"a/b", "c/#"}
+			return u
+		},
+	},
+	{
+		name: "UNSUBACK",
+		wire: "b0 02 00 0b",
+		packet: func() ControlPacket {
+			ua := NewUnsubackPacket()
+			ua.MessageID = 11
+			return ua
+		},
+	},
+	{
+		name:   "PINGREQ",
+		wire:   "c0 00",
+		packet: func() ControlPacket { return NewPingreqPacket() },
+	},
+	{
+		name:   "PINGRESP",
+		wire:   "d0 00",
+		packet: func() ControlPacket { return NewPingrespPacket() },
+	},
+	{
+		name:   "DISCONNECT",
+		wire:   "e0 00",
+		packet: func() ControlPacket { return NewDisconnectPacket() },
+	},
+	{
+		name:    "CONNECT 5 properties",
+		version: MQTT5,
+		// properties: session expiry 10, receive maximum 20
+		wire: "10 16 00 04 4d 51 54 54 05 02 00 3c 08 11 00 00 00 0a 21 00 14 00 01 63",
+		packet: func() ControlPacket {
+			expiry, receive := uint32(10), uint16(20)
+			c := NewConnectPacket()
+			c.ProtocolName = "MQTT"
+			c.ProtocolVersion = MQTT5
+			c.CleanSession = true
+			c.Keepalive = 60
+			c.Properties = &Properties{SessionExpiry: &expiry, ReceiveMaximum: &receive}
+			c.ClientIdentifier = "c"
+			return c
+		},
+	},
+	{
+		name:    "CONNECT 5 will properties",
+		version: MQTT5,
+		// no properties, will properties: payload format 1
+		wire: "10 17 00 04 4d 51 54 54 05 06 00 3c 00 00 01 63 02 01 01 00 01 77 00 01 78",
+		packet: func() ControlPacket {
+			format := byte(1)
+			c := NewConnectPacket()
+			c.ProtocolName = "MQTT"
+			c.ProtocolVersion = MQTT5
+			c.CleanSession = true
+			c.WillFlag = true
+			c.Keepalive = 60
+			c.ClientIdentifier = "c"
+			c.WillProperties = &Properties{PayloadFormat: &format}
+			c.WillTopic = "w"
+			c.WillMessage = []byte("x")
+			return c
+		},
+	},
+	{
+		name:    "CONNACK 5 assigned client identifier",
+		version: MQTT5,
+		wire:    "20 08 01 00 05 12 00 02 61 62",
+		packet: func() ControlPacket {
+			ca := NewConnackPacket()
+			ca.SessionPresent = true
+			ca.Properties = &Properties{AssignedClientID: "ab"}
+			return ca
+		},
+	},
+	{
+		name:    "CONNACK 5 not authorized",
+		version: MQTT5,
+		wire:    "20 03 00 87 00",
+		packet: func() ControlPacket {
+			ca := NewConnackPacket()
+			ca.ReturnCode = 0x87
+			return ca
+		},
+	},
+	{
+		name:    "PUBLISH 5 properties",
+		version: MQTT5,
+		// properties: topic alias 10, user property a=b
+		wire: "32 12 00 01 61 00 01 0a 23 00 0a 26 00 01 61 00 01 62 68 69",
+		packet: func() ControlPacket {
+			alias := uint16(10)
+			p := NewPublishPacket()
+			p.QoS = 1
+			p.TopicName = "a"
+			p.MessageID = 1
+			p.Properties = &Properties{TopicAlias: &alias, User: []UserProperty{{"a", "b"}}}
+			p.Payload = []byte("hi")
+			return p
+		},
+	},
+	{
+		name:    "PUBLISH 5 topic alias",
+		version: MQTT5,
+		// empty topic name, topic alias 5
+		wire: "30 07 00 00 03 23 00 05 78",
+		packet: func() ControlPacket {
+			alias := uint16(5)
+			p := NewPublishPacket()
+			p.Properties = &Properties{TopicAlias: &alias}
+			p.Payload = []byte("x")
+			return p
+		},
+	},
+	{
+		name:    "PUBACK 5 short form",
+		version: MQTT5,
+		wire:    "40 02 00 01",
+		packet: func() ControlPacket {
+			pa := NewPubackPacket()
+			pa.MessageID = 1
+			return pa
+		},
+	},
+	{
+		name:    "PUBACK 5 no matching subscribers",
+		version: MQTT5,
+		wire:    "40 03 00 01 10",
+		packet: func() ControlPacket {
+			pa := NewPubackPacket()
+			pa.MessageID = 1
+			pa.ReasonCode = 0x10
+			return pa
+		},
+	},
+	{
+		name:    "PUBREC 5 reason string",
+		version: MQTT5,
+		wire:    "50 09 00 02 87 05 1f 00 02 6e 6f",
+		packet: func() ControlPacket {
+			pr := NewPubrecPacket()
+			pr.MessageID = 2
+			pr.ReasonCode = 0x87
+			pr.Properties = &Properties{ReasonString: "no"}
+			return pr
+		},
+	},
+	{
+		name:    "PUBREL 5 packet identifier not found",
+		version: MQTT5,
+		wire:    "62 03 00 03 92",
+		packet: func() ControlPacket {
+			pr := NewPubrelPacket()
+			pr.MessageID = 3
+			pr.ReasonCode = 0x92
+			return pr
+		},
+	},
+	{
+		name:    "PUBCOMP 5 short form",
+		version: MQTT5,
+		wire:    "70 02 00 04",
+		packet: func() ControlPacket {
+			pc := NewPubcompPacket()
+			pc.MessageID = 4
+			return pc
+		},
+	},
+	{
+		name:    "AUTH 5 continue authentication",
+		version: MQTT5,
+		// properties: authentication method TEST, authentication data x
+		wire: "f0 0d 18 0b 15 00 04 54 45 53 54 16 00 01 78",
+		packet: func() ControlPacket {
+			a := NewAuthPacket()
+			a.ReasonCode = ReasonContinueAuthentication
+			a.Properties = &Properties{AuthMethod: "TEST", AuthData: []byte("x")}
+			return a
+		},
+	},
+	{
+		name:    "AUTH 5 success short form",
+		version: MQTT5,
+		wire:    "f0 00",
+		packet:  func() ControlPacket { return NewAuthPacket() },
+	},
+	{
+		name:    "SUBSCRIBE 5 subscription options",
+		version: MQTT5,
+		// properties: subscription identifier 5, options 0x2d: QoS 1, no
+		// local, retain as published, retain handling 2
+		wire: "82 0f 00 0a 02 0b 05 00 03 61 2f 62 2d 00 01 63 00",
+		packet: func() ControlPacket {
+			s := NewSubscribePacket()
+			s.MessageID = 10
+			s.Properties = &Properties{SubscriptionIdentifier: []int{5}}
+			s.Topics = []string{"a/b", "c"}
+			s.QoSs = []byte{1, 0}
+			s.Options = []SubscriptionOptions{{NoLocal: true, RetainAsPublished: true, RetainHandling: 2}, {}}
+			return s
+		},
+	},
+	{
+		name:    "SUBACK 5 reason string",
+		version: MQTT5,
+		wire:    "90 0a 00 0a 05 1f 00 02 6f 6b 01 a2",
+		packet: func() ControlPacket {
+			sa := NewSubackPacket()
+			sa.MessageID = 10
+			sa.Properties = &Properties{ReasonString: "ok"}
+			sa.ReturnCodes = []byte{0x01, 0xA2}
+			return sa
+		},
+	},
+	{
+		name:    "UNSUBSCRIBE 5",
+		version: MQTT5,
+		wire:    "a2 08 00 0b 00 00 03 61 2f 62",
+		packet: func() ControlPacket {
+			u := NewUnsubscribePacket()
+			u.MessageID = 11
+			u.Topics = []string{"a/b"}
+			return u
+		},
+	},
+	{
+		name:    "UNSUBACK 5 no subscription existed",
+		version: MQTT5,
+		wire:    "b0 05 00 0b 00 00 11",
+		packet: func() ControlPacket {
+			ua := NewUnsubackPacket()
+			ua.MessageID = 11
+			ua.ReasonCodes = []ReasonCode{0x00, 0x11}
+			return ua
+		},
+	},
+	{
+		name:    "DISCONNECT 5 normal short form",
+		version: MQTT5,
+		wire:    "e0 00",
+		packet:  func() ControlPacket { return NewDisconnectPacket() },
+	},
+	{
+		name:    "DISCONNECT 5 session taken over",
+		version: MQTT5,
+		wire:    "e0 01 8e",
+		packet: func() ControlPacket {
+			d := NewDisconnectPacket()
+			d.ReasonCode = 0x8E
+			return d
+		},
+	},
+	{
+		name:    "DISCONNECT 5 server moved",
+		version: MQTT5,
+		// properties: server reference b:1
+		wire: "e0 08 9d 06 1c 00 03 62 3a 31",
+		packet: func() ControlPacket {
+			d := NewDisconnectPacket()
+			d.ReasonCode = 0x9D
+			d.Properties = &Properties{ServerReference: "b:1"}
+			return d
+		},
+	},
+	{
+		name:    "DISCONNECT 5 session expiry",
+		version: MQTT5,
+		wire:    "e0 07 00 05 11 00 00 00 00",
+		packet: func() ControlPacket {
+			expiry := uint32(0)
+			d := NewDisconnectPacket()
+			d.Properties = &Properties{SessionExpiry: &expiry}
+			return d
+		},
+	},
+}
+
+func TestGoldenPackets(t *testing.T) {
+	for _, g := range goldenPackets {
+		wire := goldenBytes(t, g.wire)
+
+		expected := g.packet()
+		if g.version != 0 {
+			expected.SetVersion(g.version)
+		}
+		b, err := Marshal(expected)
+		assert.NoError(t, err, g.name)
+		assert.Equal(t, wire, b, g.name)
+		assert.Equal(t, len(wire), expected.Size(), g.name)
+
+		var (
+			cp ControlPacket
+			n  int
+		)
+		if g.version == 0 {
+			cp, n, err = Unmarshal(wire)
+		} else {
+			d := NewDecoder(bytes.NewReader(wire))
+			d.ProtocolVersion = g.version
+			cp, err = d.Decode()
+			n = len(wire)
+		}
+		if !assert.NoError(t, err, g.name) {
+			expected.Close()
+			continue
+		}
+		assert.Equal(t, len(wire), n, g.name)
+		assert.Equal(t, packetFields(expected), packetFields(cp), g.name)
+		assert.NoError(t, cp.Verify(), g.name)
+
+		cp.Close()
+		expected.Close()
+	}
+}
+
+func TestGoldenFixedHeaders(t *testing.T) {
+	headers := map[string]FixedHeader{
+		"30 00":          {MessageType: Publish},
+		"30 7f":          {MessageType: Publish, RemainingLength: 127},
+		"30 80 01":       {MessageType: Publish, RemainingLength: 128},
+		"30 ff 7f":       {MessageType: Publish, RemainingLength: 16383},
+		"30 80 80 01":    {MessageType: Publish, RemainingLength: 16384},
+		"30 ff ff 7f":    {MessageType: Publish, RemainingLength: 2097151},
+		"30 80 80 80 01": {MessageType: Publish, RemainingLength: 2097152},
+		"3d ff ff ff 7f": {MessageType: Publish, Dup: true, QoS: 2, Retain: true, RemainingLength: MaxRemainingLength},
+	}
+	for s, fh := range headers {
+		wire := goldenBytes(t, s)
+
+		var b bytes.Buffer
+		assert.NoError(t, NewEncoder(&b).EncodeFixedHeader(&fh), s)
+		assert.Equal(t, wire, b.Bytes(), s)
+
+		got, err := NewDecoder(bytes.NewReader(wire)).DecodeFixedHeader()
+		assert.NoError(t, err, s)
+		fh.Version = MQTT311
+		assert.Equal(t, fh, *got, s)
+	}
+}
+
+// goldenBytes parses the space separated hex bytes of s
+func goldenBytes(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatalf("bad golden bytes %q: %v", s, err)
+	}
+	return b
+}
+
+// packetFields returns the fields of the packet carried on the wire, empty
+// byte slices are made nil so a decoded packet compares equal to a built one
+func packetFields(cp ControlPacket) map[string]interface{} {
+	fields := make(map[string]interface{})
+	v := reflect.ValueOf(cp).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f, name := v.Field(i), v.Type().Field(i).Name
+		switch {
+		case name == "TraceID" || v.Type().Field(i).PkgPath != "":
+		case name == "FixedHeader":
+			fields[name] = *f.Interface().(*FixedHeader)
+		case f.Kind() == reflect.Slice && f.Len() == 0:
+			fields[name] = nil
+		default:
+			fields[name] = f.Interface()
+		}
+	}
+	return fields
+}
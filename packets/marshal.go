@@ -0,0 +1,77 @@
+package packets
+
+import "io"
+
+// appendWriter is an io.Writer appending everything written into b
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+// Marshal returns the wire format of p, fixed header included. Its strings
+// are checked at UTF8CheckSpec.
+func Marshal(p ControlPacket) ([]byte, error) {
+	if err := verifyStrings(p, UTF8CheckSpec); err != nil {
+		return nil, refused(err)
+	}
+	w := appendWriter{b: make([]byte, 0, p.Size())}
+	if _, err := p.Write(&w); err != nil {
+		return nil, err
+	}
+	return w.b, nil
+}
+
+// AppendPacket appends the wire format of p to dst and returns the extended
+// buffer. dst is returned unchanged if p can not be encoded or carries a
+// string failed at UTF8CheckSpec.
+func AppendPacket(dst []byte, p ControlPacket) []byte {
+	if verifyStrings(p, UTF8CheckSpec) != nil {
+		return dst
+	}
+	if n := len(dst) + p.Size(); n > cap(dst) {
+		b := make([]byte, len(dst), n)
+		copy(b, dst)
+		dst = b
+	}
+	w := appendWriter{b: dst}
+	if _, err := p.Write(&w); err != nil {
+		return dst
+	}
+	return w.b
+}
+
+// Unmarshal decodes the packet at the head of data, which starts with the
+// fixed header. It returns the packet and the number of bytes consumed, so
+// a buffer holding several packets can be decoded one after another.
+// The returned packet does not reference data, its strings are checked at
+// UTF8CheckSpec.
+func Unmarshal(data []byte) (cp ControlPacket, n int, err error) {
+	fh := &FixedHeader{}
+	if n, err = fh.unpackBytes(data); err != nil {
+		return nil, 0, err
+	}
+	if fh.RemainingLength > len(data)-n {
+		return nil, 0, io.ErrShortBuffer
+	}
+	if err = fh.checkFlags(); err != nil {
+		return nil, 0, err
+	}
+
+	cp = NewControlPacketWithHeader(fh)
+	if cp == nil {
+		return nil, 0, unknownType(fh)
+	}
+	if err = cp.Unpack(data[n : n+fh.RemainingLength]); err != nil {
+		cp.Close()
+		return nil, 0, err
+	}
+	if err = verifyStrings(cp, UTF8CheckSpec); err != nil {
+		cp.Close()
+		return nil, 0, err
+	}
+	return cp, n + fh.RemainingLength, nil
+}
@@ -0,0 +1,74 @@
+package packets
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshal(t *testing.T) {
+	cp := NewSubackPacket()
+	cp.MessageID = 1234
+	cp.ReturnCodes = []byte{0, 1, 2, 128}
+
+	b, err := Marshal(cp)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{144, 6, 4, 210, 0, 1, 2, 128}, b)
+	cp.Close()
+}
+
+func TestAppendPacket(t *testing.T) {
+	pa := NewPubackPacket()
+	pa.MessageID = 1234
+	pr := NewPingreqPacket()
+
+	dst := make([]byte, 1, 16)
+	dst = AppendPacket(dst, pa)
+	dst = AppendPacket(dst, pr)
+	assert.Equal(t, []byte{0, 64, 2, 4, 210, 192, 0}, dst)
+	pa.Close()
+	pr.Close()
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := []byte{64, 2, 4, 210, 50, 9, 0, 3, 97, 47, 98, 4, 210, 104, 105}
+
+	packet, n, err := Unmarshal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, uint16(1234), packet.(*PubackPacket).MessageID)
+	packet.Close()
+
+	packet, n, err = Unmarshal(data[n:])
+	assert.NoError(t, err)
+	assert.Equal(t, 11, n)
+	p := packet.(*PublishPacket)
+	assert.Equal(t, "a/b", p.TopicName)
+	assert.Equal(t, []byte("hi"), p.Payload)
+	packet.Close()
+
+	_, _, err = Unmarshal(data[:3])
+	assert.Equal(t, io.ErrShortBuffer, err)
+	_, _, err = Unmarshal([]byte{64, 0x80})
+	assert.Equal(t, io.ErrShortBuffer, err)
+	_, _, err = Unmarshal([]byte{240, 0})
+	assert.True(t, errors.Is(err, ErrUnknownPacketType))
+}
+
+func TestUnmarshalNoCopy(t *testing.T) {
+	data := []byte{16, 52, 0, 4, 77, 81, 84, 84, 4, 204, 0, 0, 0, 0, 0, 4, 116,
+		101, 115, 116, 0, 12, 84, 101, 115, 116, 32, 80, 97, 121, 108, 111, 97, 100, 0, 8, 116, 101, 115, 116,
+		117, 115, 101, 114, 0, 8, 116, 101, 115, 116, 112, 97, 115, 115}
+
+	packet, n, err := UnmarshalNoCopy(data)
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	cp := packet.(*ConnectPacket)
+	assert.Equal(t, "test", cp.WillTopic)
+	assert.Equal(t, "testuser", cp.Username)
+	assert.Equal(t, []byte("testpass"), cp.Password)
+	assert.Equal(t, &data[len(data)-1], &cp.Password[len(cp.Password)-1])
+	packet.Close()
+}
@@ -0,0 +1,101 @@
+package packets
+
+import (
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// frameUnpacker is implemented by the packets able to decode without copying,
+// the fields decoded alias b instead of copying from it
+type frameUnpacker interface {
+	// unpackFrame decode b and take the ownership of f, which holds b and
+	// will be released on Close. f is nil while b is owned by the caller.
+	unpackFrame(b []byte, f *frame) error
+}
+
+// frame is a packet body buffer shared by a zero-copy decoded packet
+type frame struct {
+	b []byte
+}
+
+var _framePool = sync.Pool{
+	New: func() interface{} {
+		return &frame{}
+	},
+}
+
+// getFrame returns a frame holding size bytes from the buffer pool
+func getFrame(size int) *frame {
+	f := _framePool.Get().(*frame)
+	f.b = getBuf(size)
+	return f
+}
+
+// putFrame put the frame and its buffer back to pool, the packet aliasing it
+// must not be used any more
+func putFrame(f *frame) {
+	putBuf(f.b)
+	f.b = nil
+	_framePool.Put(f)
+}
+
+// UnmarshalNoCopy works as Unmarshal, but the TopicName and Payload of a
+// PUBLISH and the strings and bytes fields of a CONNECT alias data instead of
+// being copied. data must stay unmodified until the packet is closed.
+func UnmarshalNoCopy(data []byte) (cp ControlPacket, n int, err error) {
+	fh := &FixedHeader{}
+	if n, err = fh.unpackBytes(data); err != nil {
+		return nil, 0, err
+	}
+	if fh.RemainingLength > len(data)-n {
+		return nil, 0, io.ErrShortBuffer
+	}
+	if err = fh.checkFlags(); err != nil {
+		return nil, 0, err
+	}
+
+	cp = NewControlPacketWithHeader(fh)
+	if cp == nil {
+		return nil, 0, unknownType(fh)
+	}
+	b := data[n : n+fh.RemainingLength]
+	if fu, ok := cp.(frameUnpacker); ok {
+		err = fu.unpackFrame(b, nil)
+	} else {
+		err = cp.Unpack(b)
+	}
+	if err != nil {
+		cp.Close()
+		return nil, 0, err
+	}
+	if err = verifyStrings(cp, UTF8CheckSpec); err != nil {
+		cp.Close()
+		return nil, 0, err
+	}
+	return cp, n + fh.RemainingLength, nil
+}
+
+// bytesToString convert b to string without copying, b must not be modified
+// while the string is in use
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// decodeStringNoCopy works as decodeString, the string returned aliases b
+func decodeStringNoCopy(b []byte) (string, int, error) {
+	r, n, err := decodeBytes(b)
+	if err != nil {
+		return "", 0, err
+	}
+	return bytesToString(r), n, nil
+}
+
+// decodeBytesCopy works as decodeBytes, the bytes returned is a copy
+func decodeBytesCopy(b []byte) ([]byte, int, error) {
+	r, n, err := decodeBytes(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(make([]byte, 0, len(r)), r...), n, nil
+}
@@ -8,17 +8,10 @@ import (
 )
 
 const (
-	// BufferSize give out the byte slice size in pool
-	bufferSize = 7
-
 	// MaxRemainingLength the max length of remain
 	MaxRemainingLength = 268435455 // 0xFF, 0xFF, 0xFF, 0x7F
 )
 
-func init() {
-	NewBufferPool(bufferSize)
-}
-
 var (
 	// ErrInternal will occored on internal error, which should not happen.
 	ErrInternal = errors.New("internal error occurred")
@@ -28,11 +21,13 @@ var (
 	ErrMQTTPacketLimitation = errors.New("the mqtt packet size exceeded limitation")
 )
 
-// func MarshalPacket(b []byte) (p ControlPacket, err error) { return nil, nil }
-// func UnmarshalPacket(ControlPacket) ([]byte, error)       { return nil, nil }
-
-// WritePacket write a packet to w
-func WritePacket(w io.Writer, p ControlPacket) (int, error) { return p.Write(w) }
+// WritePacket write a packet to w, its strings are checked at UTF8CheckSpec
+func WritePacket(w io.Writer, p ControlPacket) (int, error) {
+	if err := verifyStrings(p, UTF8CheckSpec); err != nil {
+		return 0, refused(err)
+	}
+	return p.Write(w)
+}
 
 // ControlPacket defines the interface for structs intended to hold
 // decoded MQTT packets, either from being read or before being
@@ -42,12 +37,19 @@ type ControlPacket interface {
 	SetFixedHeader(*FixedHeader)
 	// SetTraceID set the traceable label into packets. whild the packet was delievering.
 	SetTraceID(id string)
-	// Verify will check the package if validate
-	Verify() bool
+	// SetVersion set the protocol level the packet is encoded and decoded with
+	SetVersion(v byte)
+	// Verify will check the packet against the specification, the
+	// violation found will be returned
+	Verify() error
 	// Type return the packet type
 	Type() byte
 	// Write the packet into Writer
 	Write(io.Writer) (int, error)
+	// WriteTo write the packet into Writer with a single vectored write
+	WriteTo(io.Writer) (int64, error)
+	// Size return the length of the packet on wire, computed from the fields
+	Size() int
 	// Unpack the given byte and fill in the control packet object fields
 	Unpack([]byte) error
 	// Reset will initialize the fields in control packet
@@ -79,7 +81,7 @@ var PacketNames = []string{
 	12: "PINGREQ",
 	13: "PINGRESP",
 	14: "DISCONNECT",
-	15: "Reserved",
+	15: "AUTH",
 }
 
 // Below are the constants assigned to each of the MQTT packet types
@@ -98,6 +100,7 @@ const (
 	Pingreq     = 12
 	Pingresp    = 13
 	Disconnect  = 14
+	Auth        = 15
 )
 
 // Below are the const definitions for error codes returned by Connect()
@@ -114,6 +117,8 @@ const (
 
 // ConnackReturnCodes is a map of the error codes constants for Connect()
 // to a string representation of the error
+//
+// Deprecated: use ReasonCode, whose Info describes the return codes too.
 var ConnackReturnCodes = map[uint8]string{
 	0:   "Connection Accepted",
 	1:   "Connection Refused: Bad Protocol Version",
@@ -130,6 +135,8 @@ const Failure = 0x80
 
 // SubackReturnCodes is a map of the error codes constants for Subscribe()
 // to a string representation of the error
+//
+// Deprecated: use ReasonCode, whose Info describes the return codes too.
 var SubackReturnCodes = map[uint8]string{
 	0:   "Subscribe succeed, Maximum QoS 0",
 	1:   "Subscribe succeed, Maximum QoS 1",
@@ -139,6 +146,9 @@ var SubackReturnCodes = map[uint8]string{
 
 // ConnErrors is a map of the errors codes constants for Connect()
 // to a Go error
+//
+// Deprecated: use the Err method of the ReasonCode of a return code,
+// ReasonCodeOf(Connack, code).Err(), whose Info describes the code too.
 var ConnErrors = map[byte]error{
 	Accepted:                        nil,
 	ErrRefusedBadProtocolVersion:    errors.New("Unnacceptable protocol version"),
@@ -155,58 +165,20 @@ var ConnErrors = map[byte]error{
 // representing the decoded MQTT packet and an error. One of these returns will
 // always be nil, a nil ControlPacket indicating an error occurred.
 // [0][1] [2 3 4] ... [m+1] ... [n]
-// 	 --- fh --- | vh |  datagram  |
+//
+//	--- fh --- | vh |  datagram  |
 func ReadPacket(r io.Reader) (cp ControlPacket, length int, err error) {
-	var fh FixedHeader
-	b := Getbuf()
-	defer Putbuf(b)
-
-	n, err := io.ReadFull(r, b.b[0:1])
-	if err != nil {
-		return nil, n, err
-	}
-	if n != 1 {
-		return nil, n, io.ErrUnexpectedEOF
-	}
-	if n = fh.unpack(r, b.b); n <= 1 {
-		return nil, n, io.ErrUnexpectedEOF
-	}
-
-	// 5 bit the packets header
-	length = n
-
-	if fh.RemainingLength > MaxRemainingLength {
-		return nil, n, ErrMQTTPacketLimitation
-	}
-
-	rb := make([]byte, fh.RemainingLength)
-	n, err = io.ReadFull(r, rb)
-	// add remain  length
-	length += n
-	if err != nil {
-		return nil, length, err
-	}
-
-	if fh.RemainingLength != n {
-		return nil, length, errors.New("Failed to read expected data")
-	}
-
-	cp = NewControlPacketWithHeader(&fh)
-	if cp == nil {
-		return nil, length, errors.New("Bad data from client")
-	}
-
-	err = cp.Unpack(rb)
-	return cp, length, err
+	return ReadPacketLimitSize(r, MaxRemainingLength)
 }
 
-// ReadPacketLimitSize read a packet with a maximum size of s from net.Conn
+// ReadPacketLimitSize read a packet with a maximum size of s from net.Conn,
+// its strings are checked at UTF8CheckSpec
 func ReadPacketLimitSize(r io.Reader, s int) (cp ControlPacket, length int, err error) {
 	var fh FixedHeader
-	b := Getbuf()
-	defer Putbuf(b)
+	b := getBuf(7)
+	defer putBuf(b)
 
-	n, err := io.ReadFull(r, b.b[0:1])
+	n, err := io.ReadFull(r, b[0:1])
 	if err != nil {
 		return nil, n, err
 	}
@@ -214,13 +186,17 @@ func ReadPacketLimitSize(r io.Reader, s int) (cp ControlPacket, length int, err
 		return nil, n, io.ErrUnexpectedEOF
 	}
 
-	if n = fh.unpack(r, b.b); n <= 1 {
-		return nil, n, io.ErrUnexpectedEOF
+	if n, err = fh.unpack(r, b); err != nil {
+		return nil, n, err
 	}
 
 	// 5 bit the header packets
 	length = n
 
+	if err = fh.checkFlags(); err != nil {
+		return nil, length, err
+	}
+
 	if fh.RemainingLength > MaxRemainingLength {
 		return nil, length, ErrMQTTPacketLimitation
 	}
@@ -229,24 +205,27 @@ func ReadPacketLimitSize(r io.Reader, s int) (cp ControlPacket, length int, err
 		return nil, length, ErrReadPacketLimitation
 	}
 
-	rb := make([]byte, fh.RemainingLength)
+	rb := getBuf(fh.RemainingLength)
+	defer putBuf(rb)
 	n, err = io.ReadFull(r, rb)
 	length += n
 	if err != nil {
 		return nil, length, err
 	}
 
-	if fh.RemainingLength != n {
-		return nil, length, errors.New("Failed to read expected data")
-	}
-
 	cp = NewControlPacketWithHeader(&fh)
 	if cp == nil {
-		return nil, length, errors.New("Bad data from client")
+		return nil, length, unknownType(&fh)
 	}
 
-	err = cp.Unpack(rb)
-	return cp, length, err
+	if err = cp.Unpack(rb); err != nil {
+		return cp, length, err
+	}
+	if err = verifyStrings(cp, UTF8CheckSpec); err != nil {
+		cp.Close()
+		return nil, length, err
+	}
+	return cp, length, nil
 }
 
 // NewControlPacket is used to create a new ControlPacket of the type specified
@@ -283,6 +262,8 @@ func NewControlPacket(packetType byte) (cp ControlPacket) {
 		cp = NewPingreqPacket()
 	case Pingresp:
 		cp = NewPingrespPacket()
+	case Auth:
+		cp = NewAuthPacket()
 	default:
 		return nil
 	}
@@ -322,6 +303,12 @@ func NewControlPacketWithHeader(fh *FixedHeader) (cp ControlPacket) {
 		cp = NewPingreqPacket()
 	case Pingresp:
 		cp = NewPingrespPacket()
+	case Auth:
+		// AUTH is reserved below the protocol level 5
+		if fh.version() != MQTT5 {
+			return nil
+		}
+		cp = NewAuthPacket()
 	default:
 		return nil
 	}
@@ -339,33 +326,35 @@ type Details struct {
 
 // FixedHeader is a struct to hold the decoded information from
 // the fixed header of an MQTT ControlPacket
-//  |  7  6  5  4 |  3  |  2   1  |  0   |
-//  | MessageType | Dup |   QoS   |Retain|
-//  | byte        | bool|  byte   | bool |
+//
+//	|  7  6  5  4 |  3  |  2   1  |  0   |
+//	| MessageType | Dup |   QoS   |Retain|
+//	| byte        | bool|  byte   | bool |
 //
 // FixedHeader only contains 5 bytes at most. And it can align to memory boundary
 // based on 64bit system and hardware
-//  | MsgType | dup | qos | retain |
-//  |        remain length         |
-//  |           ... ...            |
-//  |           ... ...            |
-//  |           ... ...            |
-//  |    ---    4 bytes     ---    |
 //
-//  FixedHeader memory layout
-//  |    MsgType    |-----
-//  |      dup      |    |
-//  |      qos      |    |
-//  |    retain     | 8 bytes
-//  | remain length0|    |
-//  | remain length1|    |
-//  | remain length2|    |
-//  | remain length3|-----
+//	| MsgType | dup | qos | retain |
+//	|        remain length         |
+//	|           ... ...            |
+//	|           ... ...            |
+//	|           ... ...            |
+//	|    ---    4 bytes     ---    |
+//
+//	FixedHeader memory layout, 16 bytes on 64bit systems
+//	|    MsgType    |-----
+//	|      dup      |    |
+//	|      qos      |    |
+//	|    retain     | 8 bytes
+//	|    version    |    |
+//	|   3 padding   |-----
+//	| remain length | 8 bytes
 type FixedHeader struct {
 	MessageType     byte
 	Dup             bool
 	QoS             byte
 	Retain          bool
+	Version         byte // protocol level of the connection, not on the wire, zero is MQTT311
 	RemainingLength int
 }
 
@@ -386,18 +375,133 @@ func (fh *FixedHeader) pack(dst []byte) (n int) {
 
 	// | Message Type |DUP flg|  QoS  |Retain|
 	// |      4       |   1   |   2   |   1  |
-	dst[4-n] = byte(fh.MessageType<<4 | boolToByte(fh.Dup)<<3 | fh.QoS<<1 | boolToByte(fh.Retain))
+	// only PUBLISH carries its own flags, the others always emit the ones
+	// mandated by section 2.2.2
+	flags := fixedHeaderFlags[fh.MessageType&0x0F]
+	if fh.MessageType == Publish {
+		flags = fh.flags()
+	}
+	dst[4-n] = fh.MessageType<<4 | flags
 	return n + 1
 }
 
-func (fh *FixedHeader) unpack(r io.Reader, b []byte) int {
-	var n int
-	fh.MessageType = b[0] >> 4
-	fh.Dup = (b[0]>>3)&0x01 > 0
-	fh.QoS = (b[0] >> 1) & 0x03
-	fh.Retain = b[0]&0x01 > 0
-	fh.RemainingLength, n = decodeLength(r, b[1:])
-	return n + 1
+// fixedHeaderFlags maps the packet types to the flags mandated by section 2.2.2
+// of the specification, PUBLISH flags are the DUP, QoS and RETAIN of the message
+var fixedHeaderFlags = [16]byte{
+	Pubrel:      0x02,
+	Subscribe:   0x02,
+	Unsubscribe: 0x02,
+}
+
+// flags returns the lower 4 bits of the first byte of the fixed header
+func (fh *FixedHeader) flags() byte {
+	return boolToByte(fh.Dup)<<3 | (fh.QoS&0x03)<<1 | boolToByte(fh.Retain)
+}
+
+// checkFlags checks the flags decoded against section 2.2.2, the reserved
+// packet types, AUTH below the protocol level 5 included, are left to
+// NewControlPacketWithHeader
+func (fh *FixedHeader) checkFlags() error {
+	switch fh.MessageType {
+	case 0:
+		return nil
+	case Auth:
+		if fh.version() != MQTT5 {
+			return nil
+		}
+		if fh.flags() != 0 {
+			return flagsError(fh, "MQTT-2.2.2-2", "reserved flags 0x%X set", fh.flags())
+		}
+	case Publish:
+		if fh.QoS == 3 {
+			return flagsError(fh, "MQTT-3.3.1-4", "QoS 3")
+		}
+		return nil
+	case Pubrel:
+		if fh.flags() != 0x02 {
+			return flagsError(fh, "MQTT-3.6.1-1", "flags 0x%X not 0x2", fh.flags())
+		}
+	case Subscribe:
+		if fh.flags() != 0x02 {
+			return flagsError(fh, "MQTT-3.8.1-1", "flags 0x%X not 0x2", fh.flags())
+		}
+	case Unsubscribe:
+		if fh.flags() != 0x02 {
+			return flagsError(fh, "MQTT-3.10.1-1", "flags 0x%X not 0x2", fh.flags())
+		}
+	default:
+		if fh.flags() != 0 {
+			return flagsError(fh, "MQTT-2.2.2-2", "reserved flags 0x%X set", fh.flags())
+		}
+	}
+	return nil
+}
+
+// unpack decodes the fixed header, whose first byte is in b[0], the remaining
+// length is read from r. It returns the length of the fixed header.
+func (fh *FixedHeader) unpack(r io.Reader, b []byte) (int, error) {
+	fh.unpackFlags(b[0])
+	rl, n, err := decodeLength(r, b[1:])
+	if err != nil {
+		return n + 1, fh.lengthError(n, err)
+	}
+	fh.RemainingLength = rl
+	return n + 1, nil
+}
+
+// unpackBytes decode the fixed header at the head of b and return its length,
+// io.ErrShortBuffer will be returned if b does not hold a whole fixed header
+func (fh *FixedHeader) unpackBytes(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, io.ErrShortBuffer
+	}
+	fh.unpackFlags(b[0])
+	rl, n, err := DecodeVarint(b[1:])
+	if err != nil {
+		return 0, fh.lengthError(n, err)
+	}
+	fh.RemainingLength = rl
+	return n + 1, nil
+}
+
+// lengthError converts the varint errors of the remaining length, whose
+// n-th byte is in error, into ProtocolError
+func (fh *FixedHeader) lengthError(n int, err error) error {
+	if err != ErrVarintOverflow && err != ErrVarintNotMinimal {
+		return err
+	}
+	return &ProtocolError{
+		PacketType: fh.MessageType,
+		Offset:     n,
+		Reason:     "bad remaining length",
+		Close:      true,
+		Err:        err,
+	}
+}
+
+func (fh *FixedHeader) unpackFlags(b byte) {
+	fh.MessageType = b >> 4
+	fh.Dup = (b>>3)&0x01 > 0
+	fh.QoS = (b >> 1) & 0x03
+	fh.Retain = b&0x01 > 0
+}
+
+// writeFrame packs fh into the 5 bytes reserved at the head of b and writes
+// the fixed header with the body following it in a single Write
+func writeFrame(w io.Writer, fh *FixedHeader, b []byte) (int64, error) {
+	fh.RemainingLength = len(b) - 5
+	m := fh.pack(b[:5])
+	if m == 0 {
+		return 0, ErrMQTTPacketLimitation
+	}
+	n, err := w.Write(b[5-m:])
+	return int64(n), err
+}
+
+// packetSize returns the wire length of a packet with remaining length rl,
+// consistent with the fixed header FixedHeader.pack emits
+func packetSize(rl int) int {
+	return 1 + VarintSize(rl) + rl
 }
 
 // boolToByte wtire a byte into dst
@@ -448,10 +552,12 @@ func decodeBytes(b []byte) ([]byte, int, error) {
 	if err != nil {
 		return nil, 0, err
 	}
-	if len(b) < int(2+fieldLength) {
+	// widen before adding, 2+fieldLength wraps around on uint16
+	n := 2 + int(fieldLength)
+	if len(b) < n {
 		return nil, 0, io.ErrShortBuffer
 	}
-	return b[2 : 2+fieldLength], 2 + int(fieldLength), nil
+	return b[2:n], n, nil
 }
 
 // encodeBytes encode field length and field into dst
@@ -464,46 +570,34 @@ func encodeBytes(field []byte, dst []byte) error {
 	return nil
 }
 
-// follow MQTT v3.1 implements
-// buf should be a 5 length slice
+// encodeLength encodes length at the bottom of the 5 bytes buf and returns
+// the number of bytes written, 0 if length can not be encoded
 func encodeLength(length int, buf []byte) (n int) {
 	// [ 0   1   2   3   4]
 	// | flg | len(max 4) |
-	for n = 1; n < 5; n++ {
-		// FIXME Concern about BigEndian or LittleEndian crossing different platform
-		buf[n] = byte(length % 0x80) // 0x80 = 128 = 1000 0000
-		length /= 0x80
-
-		// if there are more data to encode, set the top bit of this byte
-		if length > 0 {
-			buf[n] |= 0x80
-		} else {
-			break
-		}
-	}
-
-	// shrink slice to bottom
-	for i := n; i > 0 && n != 4; i-- {
-		buf[i+4-n] = buf[i]
+	if length < 0 || length > MaxRemainingLength {
+		return 0
 	}
+	var b [4]byte
+	n = putVarint(b[:], length)
+	copy(buf[5-n:5], b[:n])
 	return n
 }
 
-// follow MQTT v3.1 implements
-func decodeLength(r io.Reader, bs []byte) (value, n int) {
-	var multiplier = 1
-	// bs max size 4 byte
-	for n = 0; n < 4; n++ {
-		if nn, err := io.ReadFull(r, bs[n:n+1]); err != nil {
-			return 0, -(n + nn)
+// decodeLength reads a remaining length from r byte by byte into bs, which
+// must hold 4 bytes, and returns it with the number of bytes read
+func decodeLength(r io.Reader, bs []byte) (value, n int, err error) {
+	var done bool
+	for n = 0; !done; n++ {
+		if _, err = io.ReadFull(r, bs[n:n+1]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, n, err
 		}
-
-		// FIXME Concern about BigEndian or LittleEndian crossing different platform
-		value += (int(bs[n]&0x7f) * multiplier)
-		multiplier *= 128
-		if (bs[n] & 0x80) == 0 { // 0x80 = 128 = 1000 0000 ; 0x7f = 127 = 0111 1111
-			break
+		if value, done, err = varintStep(value, n, bs[n]); err != nil {
+			return 0, n + 1, err
 		}
 	}
-	return value, n + 1
+	return value, n, nil
 }
@@ -2,6 +2,8 @@ package packets
 
 import (
 	"bytes"
+	"errors"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -233,7 +235,7 @@ func TestEncoding(t *testing.T) {
 	}
 	buf := make([]byte, 5)
 	for length, encoded := range lengths {
-		if res, _ := decodeLength(bytes.NewBuffer(encoded), buf); res != length {
+		if res, _, err := decodeLength(bytes.NewBuffer(encoded), buf); res != length || err != nil {
 			t.Errorf("decodeLength([0x%X]) did not return %d, but %d", encoded, length, res)
 		}
 		res := make([]byte, 64)
@@ -242,3 +244,151 @@ func TestEncoding(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteTo(t *testing.T) {
+	s := NewSubscribePacket()
+	s.MessageID = 1234
+	s.Topics = []string{"a/b", "c"}
+	s.QoSs = []byte{1, 2}
+	c := NewConnectPacket()
+	c.ProtocolName = "MQTT"
+	c.ProtocolVersion = 4
+	c.ClientIdentifier = "test"
+	c.UsernameFlag = true
+	c.Username = "tom"
+
+	p := NewPublishPacket()
+	p.QoS = 1
+	p.TopicName = "a/b"
+	p.MessageID = 1234
+	p.Payload = []byte("hi")
+
+	packets := []ControlPacket{c, s, p}
+	for i := Connack; i <= Disconnect; i++ {
+		if i != Subscribe {
+			packets = append(packets, NewControlPacket(byte(i)))
+		}
+	}
+	for _, packet := range packets {
+		w := &countWriter{}
+		n, err := packet.WriteTo(w)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(w.Len()), n, "%T length not matched", packet)
+		assert.Equal(t, 1, w.calls, "%T not written in a single Write", packet)
+		assert.Equal(t, w.Len(), packet.Size(), "%T size not matched", packet)
+
+		read, _, err := ReadPacket(&w.Buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, packet.String(), read.String())
+		read.Close()
+		packet.Close()
+	}
+}
+
+func TestPublishPacketWriteTo(t *testing.T) {
+	p := NewPublishPacket()
+	p.FixedHeader.QoS = 1
+	p.TopicName = "a/b"
+	p.MessageID = 1234
+	p.Payload = []byte("hi")
+
+	w := &bytes.Buffer{}
+	n, err := p.WriteTo(w)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.Equal(t, 11, p.Size())
+	assert.Equal(t, []byte{50, 9, 0, 3, 97, 47, 98, 4, 210, 104, 105}, w.Bytes())
+	p.Close()
+}
+
+func TestPublishPacketWriteToConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	s, err := l.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+
+	// the packet goes through the writev of the connection
+	p := NewPublishPacket()
+	p.TopicName = "a/b"
+	p.Payload = []byte("hi")
+	assert.True(t, writev(c))
+	n, err := p.WriteTo(c)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(p.Size()), n)
+
+	read, _, err := ReadPacket(s)
+	if assert.NoError(t, err) {
+		assert.Equal(t, p.String(), read.String())
+		read.Close()
+	}
+	p.Close()
+}
+
+func TestPacketSize(t *testing.T) {
+	p := NewPublishPacket()
+	for _, l := range []int{0, 120, 121, 16376, 16377, 2097144, 2097145} {
+		p.TopicName = "a"
+		p.Payload = make([]byte, l)
+		b, err := Marshal(p)
+		assert.NoError(t, err)
+		assert.Equal(t, len(b), p.Size(), "payload length %d", l)
+	}
+	p.Close()
+}
+
+func TestFixedHeaderFlags(t *testing.T) {
+	invalid := map[string][]byte{
+		"PUBREL 0x0":      {96, 2, 0, 1},
+		"SUBSCRIBE 0x0":   {128, 6, 0, 1, 0, 1, 97, 1},
+		"UNSUBSCRIBE 0x3": {163, 5, 0, 1, 0, 1, 97},
+		"PUBLISH QoS 3":   {54, 5, 0, 1, 97, 0, 1},
+		"PUBACK 0x2":      {66, 2, 0, 1},
+		"PINGREQ 0x1":     {193, 0},
+	}
+	for name, data := range invalid {
+		_, _, err := ReadPacket(bytes.NewBuffer(data))
+		assert.True(t, errors.Is(err, ErrBadFlags), name)
+		_, _, err = Unmarshal(data)
+		assert.True(t, errors.Is(err, ErrBadFlags), name)
+		_, err = NewDecoder(bytes.NewBuffer(data)).Decode()
+		assert.True(t, errors.Is(err, ErrBadFlags), name)
+		_, err = NewParser(0).Feed(data)
+		assert.True(t, errors.Is(err, ErrBadFlags), name)
+	}
+
+	// the mandated flags are emitted whatever the fixed header holds
+	pr := NewPubrelPacket()
+	pr.MessageID = 1
+	pr.QoS = 0
+	s := NewSubscribePacket()
+	s.MessageID = 1
+	s.Topics = []string{"a"}
+	s.QoSs = []byte{1}
+	s.QoS = 0
+	pa := NewPubackPacket()
+	pa.MessageID = 1
+	pa.Retain = true
+	for _, cp := range []ControlPacket{pr, s, pa} {
+		b, err := Marshal(cp)
+		assert.NoError(t, err)
+		read, _, err := Unmarshal(b)
+		assert.NoError(t, err, "%T", cp)
+		read.Close()
+	}
+	b, _ := Marshal(pr)
+	assert.Equal(t, byte(0x62), b[0])
+	pr.Close()
+	s.Close()
+	pa.Close()
+}
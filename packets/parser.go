@@ -0,0 +1,195 @@
+package packets
+
+import "github.com/arthurkiller/mqtgo/topic"
+
+// Below are the states of Parser
+const (
+	parseType = iota
+	parseLength
+	parseBody
+)
+
+// Parser is an incremental push-style MQTT decoder for non-blocking event
+// loops. Bytes are fed in chunks of any size, the partial fixed header and
+// body are kept between calls and complete packets are returned as soon as
+// their last byte arrived. It is not safe for concurrent use.
+type Parser struct {
+	// UTF8 is the validation level of the MQTT strings decoded,
+	// UTF8CheckSpec by default.
+	UTF8 UTF8Check
+	// Topics applies its topic rules, such as a maximum number of levels,
+	// to the topic names and topic filters parsed, nil checks nothing.
+	Topics *topic.Validator
+	// ProtocolVersion is the protocol level of the connection, every
+	// packet is decoded with it. It is set from the first CONNECT packet
+	// parsed with a supported protocol level.
+	ProtocolVersion byte
+	// State, when set, is the protocol state shared with the other side of
+	// the connection, ProtocolVersion follows it. A second CONNECT is
+	// refused either way.
+	State *ConnState
+
+	limit int
+
+	state    int
+	fh       FixedHeader
+	lenBytes int
+	buf      []byte
+
+	out []ControlPacket
+	err error
+
+	// local is the protocol state when State is nil
+	local ConnState
+}
+
+// NewParser returns a parser limiting the remaining length of packets to s
+// as ReadPacketLimitSize does, s <= 0 means MaxRemainingLength.
+func NewParser(s int) *Parser {
+	if s <= 0 || s > MaxRemainingLength {
+		s = MaxRemainingLength
+	}
+	return &Parser{limit: s, UTF8: UTF8CheckSpec, ProtocolVersion: MQTT311}
+}
+
+// Reset drops the partial packet and the error state, so the parser can be
+// used on a new connection. The protocol level goes back to MQTT311, State
+// included.
+func (p *Parser) Reset() {
+	p.ProtocolVersion = MQTT311
+	p.connState().Reset(MQTT311)
+	p.state = parseType
+	p.buf = p.buf[:0]
+	p.out = p.out[:0]
+	p.err = nil
+}
+
+// connState returns the protocol state of the connection, the local one holds
+// ProtocolVersion
+func (p *Parser) connState() *ConnState {
+	if p.State != nil {
+		return p.State
+	}
+	p.local.version = p.ProtocolVersion
+	return &p.local
+}
+
+// Buffered returns the number of body bytes of the partial packet held
+// by the parser.
+func (p *Parser) Buffered() int {
+	return len(p.buf)
+}
+
+// Feed parses the chunk b and returns the packets completed by it. The slice
+// returned is reused by the next call to Feed, the packets in it are owned by
+// the caller. b is not retained.
+//
+// Once an error is returned the stream can not be resynchronised, Feed will
+// keep returning that error until Reset is called. The packets completed
+// before the error are still returned.
+func (p *Parser) Feed(b []byte) ([]ControlPacket, error) {
+	p.out = p.out[:0]
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	for len(b) > 0 {
+		switch p.state {
+		case parseType:
+			p.ProtocolVersion = p.connState().Version()
+			p.fh = FixedHeader{Version: p.ProtocolVersion}
+			p.fh.unpackFlags(b[0])
+			if err := p.fh.checkFlags(); err != nil {
+				return p.out, p.fail(err)
+			}
+			p.lenBytes = 0
+			p.state = parseLength
+			b = b[1:]
+
+		case parseLength:
+			rl, done, err := varintStep(p.fh.RemainingLength, p.lenBytes, b[0])
+			b = b[1:]
+			if err != nil {
+				return p.out, p.fail(p.fh.lengthError(p.lenBytes+1, err))
+			}
+			p.fh.RemainingLength = rl
+			p.lenBytes++
+			if !done {
+				continue
+			}
+			if p.fh.RemainingLength > p.limit {
+				return p.out, p.fail(ErrReadPacketLimitation)
+			}
+			p.buf = p.buf[:0]
+			p.state = parseBody
+			if p.fh.RemainingLength == 0 {
+				if err := p.emit(nil); err != nil {
+					return p.out, err
+				}
+			}
+
+		case parseBody:
+			need := p.fh.RemainingLength - len(p.buf)
+			if len(p.buf) == 0 && len(b) >= need {
+				// the whole body is in b, unpack it in place
+				if err := p.emit(b[:need]); err != nil {
+					return p.out, err
+				}
+				b = b[need:]
+				continue
+			}
+			if need > len(b) {
+				need = len(b)
+			}
+			p.buf = append(p.buf, b[:need]...)
+			b = b[need:]
+			if len(p.buf) == p.fh.RemainingLength {
+				err := p.emit(p.buf)
+				p.buf = p.buf[:0]
+				if cap(p.buf) > maxRetainedBuffer {
+					p.buf = nil
+				}
+				if err != nil {
+					return p.out, err
+				}
+			}
+		}
+	}
+	return p.out, nil
+}
+
+// emit unpacks the body of the current packet and queues it
+func (p *Parser) emit(body []byte) error {
+	fh := p.fh
+	p.state = parseType
+	cp := NewControlPacketWithHeader(&fh)
+	if cp == nil {
+		return p.fail(unknownType(&fh))
+	}
+	if err := cp.Unpack(body); err != nil {
+		cp.Close()
+		return p.fail(err)
+	}
+	if err := verifyStrings(cp, p.UTF8); err != nil {
+		cp.Close()
+		return p.fail(err)
+	}
+	if err := verifyTopics(cp, p.Topics); err != nil {
+		cp.Close()
+		return p.fail(err)
+	}
+	if c, ok := cp.(*ConnectPacket); ok {
+		var err error
+		if p.ProtocolVersion, err = p.connState().connect(c); err != nil {
+			cp.Close()
+			return p.fail(err)
+		}
+	}
+	p.out = append(p.out, cp)
+	return nil
+}
+
+func (p *Parser) fail(err error) error {
+	p.err = err
+	return err
+}
@@ -0,0 +1,87 @@
+package packets
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParserFeed(t *testing.T) {
+	stream := []byte{50, 9, 0, 3, 97, 47, 98, 4, 210, 104, 105, 64, 2, 4, 210, 192, 0}
+
+	// feed the stream with every chunk size
+	for size := 1; size <= len(stream); size++ {
+		p := NewParser(0)
+		var got []ControlPacket
+		for i := 0; i < len(stream); i += size {
+			end := i + size
+			if end > len(stream) {
+				end = len(stream)
+			}
+			packets, err := p.Feed(stream[i:end])
+			assert.NoError(t, err)
+			got = append(got, packets...)
+		}
+		assert.Equal(t, 3, len(got), "chunk size %d", size)
+		assert.Equal(t, 0, p.Buffered())
+
+		pb := got[0].(*PublishPacket)
+		assert.Equal(t, "a/b", pb.TopicName)
+		assert.Equal(t, uint16(1234), pb.MessageID)
+		assert.Equal(t, []byte("hi"), pb.Payload)
+		assert.Equal(t, uint16(1234), got[1].(*PubackPacket).MessageID)
+		assert.Equal(t, byte(Pingreq), got[2].Type())
+		for _, cp := range got {
+			cp.Close()
+		}
+	}
+}
+
+func TestParserPartial(t *testing.T) {
+	p := NewParser(0)
+	packets, err := p.Feed([]byte{50, 9, 0, 3, 97})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(packets))
+	assert.Equal(t, 3, p.Buffered())
+
+	packets, err = p.Feed([]byte{47, 98, 4, 210, 104, 105, 64})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(packets))
+	assert.Equal(t, []byte("hi"), packets[0].(*PublishPacket).Payload)
+	packets[0].Close()
+}
+
+func TestParserLimitation(t *testing.T) {
+	p := NewParser(4)
+	packets, err := p.Feed([]byte{64, 2, 4, 210, 144, 6})
+	assert.Equal(t, ErrReadPacketLimitation, err)
+	assert.Equal(t, 1, len(packets))
+	packets[0].Close()
+
+	_, err = p.Feed([]byte{4, 210})
+	assert.Equal(t, ErrReadPacketLimitation, err)
+
+	p.Reset()
+	_, err = p.Feed([]byte{48, 0xFF, 0xFF, 0xFF, 0xFF})
+	assert.True(t, errors.Is(err, ErrVarintOverflow))
+
+	p.Reset()
+	_, err = p.Feed([]byte{240, 0})
+	assert.True(t, errors.Is(err, ErrUnknownPacketType))
+}
+
+func TestParserProtocolVersion(t *testing.T) {
+	p := NewParser(0)
+	packets, err := p.Feed([]byte{16, 17, 0, 6, 77, 81, 73, 115, 100, 112, 3, 2, 0, 10, 0, 3, 97, 98, 99, 192, 0})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(packets))
+	assert.Equal(t, MQTT31, p.ProtocolVersion)
+	assert.Equal(t, MQTT31, packets[1].(*PingreqPacket).Version)
+	for _, cp := range packets {
+		cp.Close()
+	}
+
+	p.Reset()
+	assert.Equal(t, MQTT311, p.ProtocolVersion)
+}
@@ -33,13 +33,16 @@ func (pr *PingrespPacket) Reset() {
 	pr.FixedHeader.QoS = byte(0)
 	pr.FixedHeader.RemainingLength = 0
 	pr.FixedHeader.Retain = false
+	pr.FixedHeader.Version = 0
 }
 
 // SetTraceID will set traceid for tracing
 func (pr *PingrespPacket) SetTraceID(string) {}
 
-// Verify packet availability
-func (pr *PingrespPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification
+func (pr *PingrespPacket) Verify() error {
+	return nil
+}
 
 // SetFixedHeader will set fh for our header
 func (pr *PingrespPacket) SetFixedHeader(fh *FixedHeader) {
@@ -59,10 +62,23 @@ func (pr *PingrespPacket) String() string {
 
 // Write will write the packets mostly into a net.Conn
 func (pr *PingrespPacket) Write(w io.Writer) (int, error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	pr.FixedHeader.pack(b.b[:5])
-	return w.Write(b.b[3:5])
+	n, err := pr.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (pr *PingrespPacket) WriteTo(w io.Writer) (int64, error) {
+	b := getBuf(7)
+	defer putBuf(b)
+	pr.FixedHeader.RemainingLength = 0
+	pr.FixedHeader.pack(b[:5])
+	n, err := w.Write(b[3:5])
+	return int64(n), err
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (pr *PingrespPacket) Size() int {
+	return packetSize(0)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -0,0 +1,639 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ErrPropertyTooLong return on a string, binary data or user property longer
+// than the 65535 bytes its length prefix can hold
+var ErrPropertyTooLong = fmt.Errorf("property longer than 65535 bytes: %w", ErrMalformedPacket)
+
+// Below are the identifiers of the MQTT 5 properties
+const (
+	PropPayloadFormat          = 0x01
+	PropMessageExpiry          = 0x02
+	PropContentType            = 0x03
+	PropResponseTopic          = 0x08
+	PropCorrelationData        = 0x09
+	PropSubscriptionIdentifier = 0x0B
+	PropSessionExpiry          = 0x11
+	PropAssignedClientID       = 0x12
+	PropServerKeepAlive        = 0x13
+	PropAuthMethod             = 0x15
+	PropAuthData               = 0x16
+	PropRequestProblemInfo     = 0x17
+	PropWillDelayInterval      = 0x18
+	PropRequestResponseInfo    = 0x19
+	PropResponseInfo           = 0x1A
+	PropServerReference        = 0x1C
+	PropReasonString           = 0x1F
+	PropReceiveMaximum         = 0x21
+	PropTopicAliasMaximum      = 0x22
+	PropTopicAlias             = 0x23
+	PropMaximumQoS             = 0x24
+	PropRetainAvailable        = 0x25
+	PropUserProperty           = 0x26
+	PropMaximumPacketSize      = 0x27
+	PropWildcardSubAvailable   = 0x28
+	PropSubIDAvailable         = 0x29
+	PropSharedSubAvailable     = 0x2A
+)
+
+// WillProperties stands for the will properties of CONNECT where a packet
+// type is expected, packet type 0 being reserved
+const WillProperties byte = 0
+
+// propertyInfo describes a property identifier
+type propertyInfo struct {
+	name string
+	// packets is the bit set of the packet types allowed to carry the
+	// property, bit WillProperties stands for the will properties
+	packets uint32
+}
+
+// propertyIDs lists the property identifiers in the order they are encoded
+var propertyIDs = []byte{
+	PropPayloadFormat, PropMessageExpiry, PropContentType, PropResponseTopic, PropCorrelationData,
+	PropSubscriptionIdentifier, PropSessionExpiry, PropAssignedClientID, PropServerKeepAlive,
+	PropAuthMethod, PropAuthData, PropRequestProblemInfo, PropWillDelayInterval,
+	PropRequestResponseInfo, PropResponseInfo, PropServerReference, PropReasonString,
+	PropReceiveMaximum, PropTopicAliasMaximum, PropTopicAlias, PropMaximumQoS, PropRetainAvailable,
+	PropUserProperty, PropMaximumPacketSize, PropWildcardSubAvailable, PropSubIDAvailable,
+	PropSharedSubAvailable,
+}
+
+var propertyInfos = map[byte]propertyInfo{
+	PropPayloadFormat:          {"payload format indicator", 1<<Publish | 1<<WillProperties},
+	PropMessageExpiry:          {"message expiry interval", 1<<Publish | 1<<WillProperties},
+	PropContentType:            {"content type", 1<<Publish | 1<<WillProperties},
+	PropResponseTopic:          {"response topic", 1<<Publish | 1<<WillProperties},
+	PropCorrelationData:        {"correlation data", 1<<Publish | 1<<WillProperties},
+	PropSubscriptionIdentifier: {"subscription identifier", 1<<Publish | 1<<Subscribe},
+	PropSessionExpiry:          {"session expiry interval", 1<<Connect | 1<<Connack | 1<<Disconnect},
+	PropAssignedClientID:       {"assigned client identifier", 1 << Connack},
+	PropServerKeepAlive:        {"server keep alive", 1 << Connack},
+	PropAuthMethod:             {"authentication method", 1<<Connect | 1<<Connack | 1<<Auth},
+	PropAuthData:               {"authentication data", 1<<Connect | 1<<Connack | 1<<Auth},
+	PropRequestProblemInfo:     {"request problem information", 1 << Connect},
+	PropWillDelayInterval:      {"will delay interval", 1 << WillProperties},
+	PropRequestResponseInfo:    {"request response information", 1 << Connect},
+	PropResponseInfo:           {"response information", 1 << Connack},
+	PropServerReference:        {"server reference", 1<<Connack | 1<<Disconnect},
+	PropReasonString: {"reason string", 1<<Connack | 1<<Puback | 1<<Pubrec | 1<<Pubrel | 1<<Pubcomp |
+		1<<Suback | 1<<Unsuback | 1<<Disconnect | 1<<Auth},
+	PropReceiveMaximum:    {"receive maximum", 1<<Connect | 1<<Connack},
+	PropTopicAliasMaximum: {"topic alias maximum", 1<<Connect | 1<<Connack},
+	PropTopicAlias:        {"topic alias", 1 << Publish},
+	PropMaximumQoS:        {"maximum QoS", 1 << Connack},
+	PropRetainAvailable:   {"retain available", 1 << Connack},
+	PropUserProperty: {"user property", 1<<Connect | 1<<Connack | 1<<Publish | 1<<Puback | 1<<Pubrec |
+		1<<Pubrel | 1<<Pubcomp | 1<<Subscribe | 1<<Suback | 1<<Unsubscribe | 1<<Unsuback |
+		1<<Disconnect | 1<<Auth | 1<<WillProperties},
+	PropMaximumPacketSize:    {"maximum packet size", 1<<Connect | 1<<Connack},
+	PropWildcardSubAvailable: {"wildcard subscription available", 1 << Connack},
+	PropSubIDAvailable:       {"subscription identifier available", 1 << Connack},
+	PropSharedSubAvailable:   {"shared subscription available", 1 << Connack},
+}
+
+// UserProperty is a name value pair of the user property
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// Properties holds the MQTT 5 properties of a packet. A nil pointer, an
+// empty string and a nil slice stand for an absent property, but the string
+// properties decoded with an empty value, or marked with SetEmpty, which are
+// kept present.
+type Properties struct {
+	PayloadFormat          *byte
+	MessageExpiry          *uint32
+	ContentType            string
+	ResponseTopic          string
+	CorrelationData        []byte
+	SubscriptionIdentifier []int
+	SessionExpiry          *uint32
+	AssignedClientID       string
+	ServerKeepAlive        *uint16
+	AuthMethod             string
+	AuthData               []byte
+	RequestProblemInfo     *byte
+	WillDelayInterval      *uint32
+	RequestResponseInfo    *byte
+	ResponseInfo           string
+	ServerReference        string
+	ReasonString           string
+	ReceiveMaximum         *uint16
+	TopicAliasMaximum      *uint16
+	TopicAlias             *uint16
+	MaximumQoS             *byte
+	RetainAvailable        *byte
+	User                   []UserProperty
+	MaximumPacketSize      *uint32
+	WildcardSubAvailable   *byte
+	SubIDAvailable         *byte
+	SharedSubAvailable     *byte
+
+	// empty is the bit set of the string properties present with an empty
+	// value
+	empty uint64
+}
+
+// field returns the pointer to the field of the property id, nil for the
+// unknown identifiers
+func (p *Properties) field(id byte) interface{} {
+	switch id {
+	case PropPayloadFormat:
+		return &p.PayloadFormat
+	case PropMessageExpiry:
+		return &p.MessageExpiry
+	case PropContentType:
+		return &p.ContentType
+	case PropResponseTopic:
+		return &p.ResponseTopic
+	case PropCorrelationData:
+		return &p.CorrelationData
+	case PropSubscriptionIdentifier:
+		return &p.SubscriptionIdentifier
+	case PropSessionExpiry:
+		return &p.SessionExpiry
+	case PropAssignedClientID:
+		return &p.AssignedClientID
+	case PropServerKeepAlive:
+		return &p.ServerKeepAlive
+	case PropAuthMethod:
+		return &p.AuthMethod
+	case PropAuthData:
+		return &p.AuthData
+	case PropRequestProblemInfo:
+		return &p.RequestProblemInfo
+	case PropWillDelayInterval:
+		return &p.WillDelayInterval
+	case PropRequestResponseInfo:
+		return &p.RequestResponseInfo
+	case PropResponseInfo:
+		return &p.ResponseInfo
+	case PropServerReference:
+		return &p.ServerReference
+	case PropReasonString:
+		return &p.ReasonString
+	case PropReceiveMaximum:
+		return &p.ReceiveMaximum
+	case PropTopicAliasMaximum:
+		return &p.TopicAliasMaximum
+	case PropTopicAlias:
+		return &p.TopicAlias
+	case PropMaximumQoS:
+		return &p.MaximumQoS
+	case PropRetainAvailable:
+		return &p.RetainAvailable
+	case PropUserProperty:
+		return &p.User
+	case PropMaximumPacketSize:
+		return &p.MaximumPacketSize
+	case PropWildcardSubAvailable:
+		return &p.WildcardSubAvailable
+	case PropSubIDAvailable:
+		return &p.SubIDAvailable
+	case PropSharedSubAvailable:
+		return &p.SharedSubAvailable
+	}
+	return nil
+}
+
+// Present reports whether the property id is present
+func (p *Properties) Present(id byte) bool {
+	return p != nil && p.size(id) > 0
+}
+
+// SetEmpty makes the string property id present with an empty value, as long
+// as its field is empty
+func (p *Properties) SetEmpty(id byte) {
+	if _, ok := p.field(id).(*string); ok {
+		p.empty |= 1 << id
+	}
+}
+
+// size returns the length of all the occurrences of the property id,
+// identifiers included
+func (p *Properties) size(id byte) int {
+	return propertySize(p.field(id), p.empty&(1<<id) != 0)
+}
+
+// Reset drops all the properties
+func (p *Properties) Reset() {
+	*p = Properties{}
+}
+
+// Copy returns a deep copy of the properties, nil for nil
+func (p *Properties) Copy() *Properties {
+	if p == nil {
+		return nil
+	}
+	c := &Properties{empty: p.empty}
+	for _, id := range propertyIDs {
+		switch dst := c.field(id).(type) {
+		case **byte:
+			if v := *p.field(id).(**byte); v != nil {
+				b := *v
+				*dst = &b
+			}
+		case **uint16:
+			if v := *p.field(id).(**uint16); v != nil {
+				u := *v
+				*dst = &u
+			}
+		case **uint32:
+			if v := *p.field(id).(**uint32); v != nil {
+				u := *v
+				*dst = &u
+			}
+		case *string:
+			*dst = *p.field(id).(*string)
+		case *[]byte:
+			if v := *p.field(id).(*[]byte); v != nil {
+				*dst = append([]byte{}, v...)
+			}
+		case *[]int:
+			if v := *p.field(id).(*[]int); v != nil {
+				*dst = append([]int{}, v...)
+			}
+		case *[]UserProperty:
+			if v := *p.field(id).(*[]UserProperty); v != nil {
+				*dst = append([]UserProperty{}, v...)
+			}
+		}
+	}
+	return c
+}
+
+// length returns the length of the encoded properties, the length prefix
+// excluded
+func (p *Properties) length() (n int) {
+	if p == nil {
+		return 0
+	}
+	for _, id := range propertyIDs {
+		n += p.size(id)
+	}
+	return n
+}
+
+// propertySize returns the length of all the occurrences of the property
+// held by the field f, identifiers included. present keeps an empty string
+// property present.
+func propertySize(f interface{}, present bool) (n int) {
+	switch v := f.(type) {
+	case **byte:
+		if *v != nil {
+			return 2
+		}
+	case **uint16:
+		if *v != nil {
+			return 3
+		}
+	case **uint32:
+		if *v != nil {
+			return 5
+		}
+	case *string:
+		if *v != "" || present {
+			return 3 + len(*v)
+		}
+	case *[]byte:
+		if *v != nil {
+			return 3 + len(*v)
+		}
+	case *[]int:
+		for _, i := range *v {
+			n += 1 + VarintSize(i)
+		}
+	case *[]UserProperty:
+		for _, u := range *v {
+			n += 5 + len(u.Key) + len(u.Value)
+		}
+	}
+	return n
+}
+
+// Size returns the length of the properties on wire, the length prefix
+// included
+func (p *Properties) Size() int {
+	n := p.length()
+	return VarintSize(n) + n
+}
+
+// Pack writes the properties and their length prefix into dst, which must
+// hold Size bytes at least, and returns the number of bytes written.
+// ErrVarintOverflow is returned if a subscription identifier or the length
+// can not be encoded, ErrPropertyTooLong if a value can not, and
+// io.ErrShortBuffer if dst is too short.
+func (p *Properties) Pack(dst []byte) (int, error) {
+	l := p.length()
+	if l > MaxRemainingLength {
+		return 0, ErrVarintOverflow
+	}
+	if len(dst) < VarintSize(l)+l {
+		return 0, io.ErrShortBuffer
+	}
+	n := putVarint(dst, l)
+	if p == nil {
+		return n, nil
+	}
+	for _, i := range p.SubscriptionIdentifier {
+		if i < 0 || i > MaxRemainingLength {
+			return 0, ErrVarintOverflow
+		}
+	}
+	if name := p.tooLong(); name != "" {
+		return 0, fmt.Errorf("%w: %s", ErrPropertyTooLong, name)
+	}
+	for _, id := range propertyIDs {
+		n += packProperty(dst[n:], id, p.field(id), p.empty&(1<<id) != 0)
+	}
+	return n, nil
+}
+
+// tooLong returns the name of the first string, binary data or user property
+// longer than 65535 bytes, empty if there is none
+func (p *Properties) tooLong() string {
+	for _, id := range propertyIDs {
+		switch v := p.field(id).(type) {
+		case *string:
+			if len(*v) > 65535 {
+				return propertyInfos[id].name
+			}
+		case *[]byte:
+			if len(*v) > 65535 {
+				return propertyInfos[id].name
+			}
+		case *[]UserProperty:
+			for _, u := range *v {
+				if len(u.Key) > 65535 || len(u.Value) > 65535 {
+					return propertyInfos[id].name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// packProperty writes all the occurrences of the property id held by the
+// field f into dst, present keeps an empty string property present
+func packProperty(dst []byte, id byte, f interface{}, present bool) (n int) {
+	switch v := f.(type) {
+	case **byte:
+		if *v != nil {
+			dst[0], dst[1] = id, **v
+			return 2
+		}
+	case **uint16:
+		if *v != nil {
+			dst[0] = id
+			encodeUint16(**v, dst[1:])
+			return 3
+		}
+	case **uint32:
+		if *v != nil {
+			dst[0] = id
+			binary.BigEndian.PutUint32(dst[1:], **v)
+			return 5
+		}
+	case *string:
+		if *v != "" || present {
+			dst[0] = id
+			encodeString(*v, dst[1:])
+			return 3 + len(*v)
+		}
+	case *[]byte:
+		if *v != nil {
+			dst[0] = id
+			encodeBytes(*v, dst[1:])
+			return 3 + len(*v)
+		}
+	case *[]int:
+		for _, i := range *v {
+			dst[n] = id
+			n += 1 + putVarint(dst[n+1:], i)
+		}
+	case *[]UserProperty:
+		for _, u := range *v {
+			dst[n] = id
+			encodeString(u.Key, dst[n+1:])
+			n += 3 + len(u.Key)
+			encodeString(u.Value, dst[n:])
+			n += 2 + len(u.Value)
+		}
+	}
+	return n
+}
+
+// Unpack decodes the properties with their length prefix at the head of b
+// for a packet of packetType, and returns the number of bytes they take.
+// The offsets of the errors returned are counted from the head of b.
+func (p *Properties) Unpack(b []byte, packetType byte) (int, error) {
+	l, n, err := DecodeVarint(b)
+	if err == io.ErrShortBuffer {
+		return 0, propertyError(packetType, len(b), ErrMalformedPacket, "truncated properties length")
+	}
+	if err != nil {
+		return 0, propertyError(packetType, n-1, ErrMalformedPacket, "bad properties length")
+	}
+	if l > len(b)-n {
+		return 0, propertyError(packetType, len(b), ErrMalformedPacket, "properties longer than the packet")
+	}
+
+	var seen uint64
+	end := n + l
+	for n < end {
+		id := b[n]
+		info, ok := propertyInfos[id]
+		if !ok {
+			return 0, propertyError(packetType, n, ErrMalformedPacket, "unknown property identifier 0x%02X", id)
+		}
+		repeatable := id == PropUserProperty || (id == PropSubscriptionIdentifier && packetType == Publish)
+		if seen&(1<<id) != 0 && !repeatable {
+			return 0, propertyError(packetType, n, ErrSpecViolation, "duplicate %s", info.name)
+		}
+		seen |= 1 << id
+
+		f := p.field(id)
+		m, err := unpackProperty(b[n+1:end], f)
+		if err != nil {
+			return 0, propertyError(packetType, n, ErrMalformedPacket, "truncated %s", info.name)
+		}
+		if s, ok := f.(*string); ok && *s == "" {
+			p.empty |= 1 << id
+		}
+		n += 1 + m
+	}
+	return n, nil
+}
+
+// unpackProperty decodes one occurrence of the property held by the field f
+// at the head of b, and returns the number of bytes it takes
+func unpackProperty(b []byte, f interface{}) (int, error) {
+	switch v := f.(type) {
+	case **byte:
+		if len(b) < 1 {
+			return 0, io.ErrShortBuffer
+		}
+		c := b[0]
+		*v = &c
+		return 1, nil
+	case **uint16:
+		i, err := decodeUint16(b)
+		if err != nil {
+			return 0, err
+		}
+		*v = &i
+		return 2, nil
+	case **uint32:
+		if len(b) < 4 {
+			return 0, io.ErrShortBuffer
+		}
+		i := binary.BigEndian.Uint32(b)
+		*v = &i
+		return 4, nil
+	case *string:
+		s, n, err := decodeString(b)
+		*v = s
+		return n, err
+	case *[]byte:
+		bs, n, err := decodeBytesCopy(b)
+		*v = bs
+		return n, err
+	case *[]int:
+		i, n, err := DecodeVarint(b)
+		if err != nil {
+			return 0, err
+		}
+		*v = append(*v, i)
+		return n, nil
+	case *[]UserProperty:
+		key, n, err := decodeString(b)
+		if err != nil {
+			return 0, err
+		}
+		value, m, err := decodeString(b[n:])
+		if err != nil {
+			return 0, err
+		}
+		*v = append(*v, UserProperty{Key: key, Value: value})
+		return n + m, nil
+	}
+	return 0, ErrInternal
+}
+
+// unpackProperties decodes the properties at offset n of the body b of fh
+// for a packet of packetType. The properties returned are nil if empty, the
+// offsets of the errors returned are counted from the head of the packet.
+func unpackProperties(fh *FixedHeader, b []byte, n int, packetType byte) (*Properties, int, error) {
+	p := &Properties{}
+	m, err := p.Unpack(b[n:], packetType)
+	if err != nil {
+		if perr, ok := err.(*ProtocolError); ok {
+			perr.Offset += packetSize(fh.RemainingLength) - fh.RemainingLength + n
+		}
+		return nil, 0, err
+	}
+	if m == 1 {
+		return nil, m, nil
+	}
+	return p, m, nil
+}
+
+// verifyPacketProperties checks the properties props of the packet of fh,
+// which may only carry some on protocol level 5
+func verifyPacketProperties(fh *FixedHeader, props *Properties) error {
+	if fh.version() != MQTT5 {
+		if props != nil {
+			return verifyError(fh.MessageType, "", "properties on protocol level %d", fh.version())
+		}
+		return nil
+	}
+	return props.Verify(fh.MessageType)
+}
+
+// packetPropertiesSize returns the length on wire of the properties props of
+// the packet of fh, zero below protocol level 5
+func packetPropertiesSize(fh *FixedHeader, props *Properties) int {
+	if fh.version() != MQTT5 {
+		return 0
+	}
+	return props.Size()
+}
+
+// Verify checks the properties carried by a packet of packetType against the
+// MQTT 5 specification: every property must be allowed for the packet type
+// and hold a valid value.
+func (p *Properties) Verify(packetType byte) error {
+	if p == nil {
+		return nil
+	}
+	pt := packetType
+	if pt == WillProperties {
+		pt = Connect
+	}
+	for _, id := range propertyIDs {
+		info := propertyInfos[id]
+		if p.size(id) > 0 && info.packets&(1<<packetType) == 0 {
+			return verifyError(pt, "", "%s property not allowed", info.name)
+		}
+	}
+
+	for _, v := range []*byte{p.PayloadFormat, p.RequestProblemInfo, p.RequestResponseInfo, p.MaximumQoS,
+		p.RetainAvailable, p.WildcardSubAvailable, p.SubIDAvailable, p.SharedSubAvailable} {
+		if v != nil && *v > 1 {
+			return verifyError(pt, "", "byte property of value %d, 0 or 1 expected", *v)
+		}
+	}
+	for _, v := range []*uint16{p.ReceiveMaximum, p.TopicAlias} {
+		if v != nil && *v == 0 {
+			return verifyError(pt, "", "receive maximum or topic alias of value 0")
+		}
+	}
+	if p.MaximumPacketSize != nil && *p.MaximumPacketSize == 0 {
+		return verifyError(pt, "", "maximum packet size of value 0")
+	}
+	if len(p.SubscriptionIdentifier) > 1 && packetType != Publish {
+		return verifyError(pt, "", "more than one subscription identifier")
+	}
+	for _, i := range p.SubscriptionIdentifier {
+		if i < 1 || i > MaxRemainingLength {
+			return verifyError(pt, "", "subscription identifier %d out of range", i)
+		}
+	}
+	if p.AuthData != nil && p.AuthMethod == "" {
+		return verifyError(pt, "", "authentication data without authentication method")
+	}
+	if name := p.tooLong(); name != "" {
+		return verifyError(pt, "", "%s property longer than 65535 bytes", name)
+	}
+	return p.verifyStrings(pt, UTF8CheckSpec)
+}
+
+// verifyStrings checks the string properties at the given level
+func (p *Properties) verifyStrings(packetType byte, check UTF8Check) error {
+	if p == nil {
+		return nil
+	}
+	for _, id := range propertyIDs {
+		if s, ok := p.field(id).(*string); ok {
+			if err := verifyString(packetType, propertyInfos[id].name, *s, check); err != nil {
+				return err
+			}
+		}
+	}
+	for _, u := range p.User {
+		if err := verifyString(packetType, "user property name", u.Key, check); err != nil {
+			return err
+		}
+		if err := verifyString(packetType, "user property value", u.Value, check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProperties(t *testing.T) {
+	expiry, format, alias := uint32(60), byte(1), uint16(3)
+	p := &Properties{
+		PayloadFormat:          &format,
+		MessageExpiry:          &expiry,
+		ContentType:            "text/plain",
+		CorrelationData:        []byte{1, 2},
+		SubscriptionIdentifier: []int{1, 16384},
+		TopicAlias:             &alias,
+		User:                   []UserProperty{{"a", "1"}, {"a", "2"}},
+	}
+	wire := []byte{48,
+		0x01, 1,
+		0x02, 0, 0, 0, 60,
+		0x03, 0, 10, 't', 'e', 'x', 't', '/', 'p', 'l', 'a', 'i', 'n',
+		0x09, 0, 2, 1, 2,
+		0x0B, 1,
+		0x0B, 0x80, 0x80, 0x01,
+		0x23, 0, 3,
+		0x26, 0, 1, 'a', 0, 1, '1',
+		0x26, 0, 1, 'a', 0, 1, '2',
+	}
+	assert.NoError(t, p.Verify(Publish))
+	assert.Equal(t, len(wire), p.Size())
+
+	b := make([]byte, p.Size())
+	n, err := p.Pack(b)
+	assert.NoError(t, err)
+	assert.Equal(t, len(wire), n)
+	assert.Equal(t, wire, b)
+
+	got := &Properties{}
+	n, err = got.Unpack(append(wire, 0xFF), Publish)
+	assert.NoError(t, err)
+	assert.Equal(t, len(wire), n)
+	assert.Equal(t, p, got)
+
+	var empty *Properties
+	assert.Equal(t, 1, empty.Size())
+	n, err = empty.Pack(b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0}, b[:n])
+
+	_, err = p.Pack(b[:len(wire)-1])
+	assert.Equal(t, io.ErrShortBuffer, err)
+	_, err = p.Pack(nil)
+	assert.Equal(t, io.ErrShortBuffer, err)
+}
+
+func TestPropertiesTooLong(t *testing.T) {
+	// the longest reason string survives the round trip
+	pa := NewPubackPacket()
+	pa.SetVersion(MQTT5)
+	pa.MessageID = 1
+	pa.ReasonCode = 0x80
+	pa.Properties = &Properties{ReasonString: strings.Repeat("a", 65535)}
+	assert.NoError(t, pa.Verify())
+	b, err := Marshal(pa)
+	assert.NoError(t, err)
+	d := NewDecoder(bytes.NewReader(b))
+	d.ProtocolVersion = MQTT5
+	cp, err := d.Decode()
+	if assert.NoError(t, err) {
+		assert.Equal(t, pa.Properties, cp.(*PubackPacket).Properties)
+		cp.Close()
+	}
+
+	for name, p := range map[string]*Properties{
+		"string":        {ReasonString: strings.Repeat("a", 65536)},
+		"binary data":   {AuthMethod: "m", AuthData: make([]byte, 65536)},
+		"user property": {User: []UserProperty{{"a", strings.Repeat("b", 65536)}}},
+	} {
+		assert.True(t, errors.Is(p.Verify(Auth), ErrSpecViolation), name)
+		_, err := p.Pack(make([]byte, p.Size()))
+		assert.True(t, errors.Is(err, ErrPropertyTooLong), name)
+	}
+	pa.Properties.ReasonString += "a"
+	assert.Error(t, pa.Verify())
+	_, err = Marshal(pa)
+	assert.True(t, errors.Is(err, ErrPropertyTooLong))
+}
+
+func TestPropertiesEmptyString(t *testing.T) {
+	// a reason string and a content type present with an empty value, an
+	// empty correlation data
+	wire := []byte{9, 0x1F, 0, 0, 0x03, 0, 0, 0x09, 0, 0}
+	p := &Properties{}
+	n, err := p.Unpack(wire, Publish)
+	assert.NoError(t, err)
+	assert.Equal(t, len(wire), n)
+	assert.True(t, p.Present(PropContentType))
+	assert.True(t, p.Present(PropReasonString))
+	assert.True(t, p.Present(PropCorrelationData))
+	assert.False(t, p.Present(PropResponseTopic))
+	assert.Error(t, p.Verify(Publish))
+
+	// the properties survive decode and encode
+	b := make([]byte, p.Size())
+	_, err = p.Pack(b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{9, 0x03, 0, 0, 0x09, 0, 0, 0x1F, 0, 0}, b)
+	assert.Equal(t, p, p.Copy())
+
+	p = &Properties{}
+	assert.False(t, p.Present(PropResponseTopic))
+	p.SetEmpty(PropResponseTopic)
+	p.SetEmpty(PropTopicAlias)
+	assert.True(t, p.Present(PropResponseTopic))
+	assert.False(t, p.Present(PropTopicAlias))
+	assert.Equal(t, 4, p.Size())
+	p.Reset()
+	assert.Equal(t, 1, p.Size())
+}
+
+func TestPropertiesUnpackErrors(t *testing.T) {
+	invalid := map[string]struct {
+		wire     []byte
+		offset   int
+		category error
+	}{
+		"truncated length":    {[]byte{0x80}, 1, ErrMalformedPacket},
+		"length overflow":     {[]byte{0x80, 0x00}, 1, ErrMalformedPacket},
+		"longer than packet":  {[]byte{3, 0x01, 1}, 3, ErrMalformedPacket},
+		"unknown identifier":  {[]byte{2, 0x04, 1}, 1, ErrMalformedPacket},
+		"truncated value":     {[]byte{3, 0x02, 0, 0, 0, 0}, 1, ErrMalformedPacket},
+		"duplicate":           {[]byte{4, 0x01, 1, 0x01, 0}, 3, ErrSpecViolation},
+		"duplicate subscribe": {[]byte{4, 0x0B, 1, 0x0B, 2}, 3, ErrSpecViolation},
+	}
+	for name, tc := range invalid {
+		var perr *ProtocolError
+		_, err := (&Properties{}).Unpack(tc.wire, Subscribe)
+		assert.True(t, errors.As(err, &perr), name)
+		assert.True(t, errors.Is(err, tc.category), name)
+		assert.Equal(t, tc.offset, perr.Offset, name)
+		assert.Equal(t, byte(Subscribe), perr.PacketType, name)
+	}
+
+	// subscription identifiers and user properties may repeat in PUBLISH
+	p := &Properties{}
+	_, err := p.Unpack([]byte{4, 0x0B, 1, 0x0B, 2}, Publish)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, p.SubscriptionIdentifier)
+}
+
+func TestPropertiesVerify(t *testing.T) {
+	zero, two, delay := uint16(0), byte(2), uint32(5)
+
+	assert.NoError(t, (&Properties{WillDelayInterval: &delay}).Verify(WillProperties))
+	assert.NoError(t, (&Properties{ReasonString: "ok"}).Verify(Puback))
+
+	invalid := map[string]struct {
+		p          *Properties
+		packetType byte
+	}{
+		"not allowed":       {&Properties{TopicAlias: &zero}, Connect},
+		"will only":         {&Properties{WillDelayInterval: &delay}, Connect},
+		"byte value":        {&Properties{PayloadFormat: &two}, Publish},
+		"zero topic alias":  {&Properties{TopicAlias: &zero}, Publish},
+		"zero subscription": {&Properties{SubscriptionIdentifier: []int{0}}, Subscribe},
+		"subscriptions":     {&Properties{SubscriptionIdentifier: []int{1, 2}}, Subscribe},
+		"auth data only":    {&Properties{AuthData: []byte{1}}, Connect},
+		"bad UTF-8":         {&Properties{ReasonString: "\xff"}, Puback},
+		"bad user property": {&Properties{User: []UserProperty{{"a", "\x00"}}}, Puback},
+	}
+	for name, tc := range invalid {
+		var perr *ProtocolError
+		err := tc.p.Verify(tc.packetType)
+		assert.True(t, errors.As(err, &perr), name)
+	}
+
+	_, err := (&Properties{SubscriptionIdentifier: []int{MaxRemainingLength + 1}}).Pack(make([]byte, 16))
+	assert.Equal(t, ErrVarintOverflow, err)
+}
+
+func TestPropertiesCopy(t *testing.T) {
+	var nilProps *Properties
+	assert.Nil(t, nilProps.Copy())
+
+	format, expiry, max := byte(1), uint32(60), uint16(10)
+	p := &Properties{PayloadFormat: &format, MessageExpiry: &expiry, ReceiveMaximum: &max,
+		ContentType: "text/plain", AuthData: []byte{}, SubscriptionIdentifier: []int{1, 2}}
+	c := p.Copy()
+	assert.Equal(t, p, c)
+	assert.NotNil(t, c.AuthData)
+	assert.Nil(t, c.CorrelationData)
+	format, expiry, max = 0, 0, 0
+	p.SubscriptionIdentifier[0] = 3
+	assert.Equal(t, byte(1), *c.PayloadFormat)
+	assert.Equal(t, uint32(60), *c.MessageExpiry)
+	assert.Equal(t, uint16(10), *c.ReceiveMaximum)
+	assert.Equal(t, []int{1, 2}, c.SubscriptionIdentifier)
+}
@@ -18,6 +18,11 @@ type PubackPacket struct {
 	*FixedHeader
 	MessageID uint16
 	TraceID   string
+
+	// ReasonCode is the reason code of protocol level 5
+	ReasonCode ReasonCode
+	// Properties are the properties of protocol level 5
+	Properties *Properties
 }
 
 // NewPubackPacket return the puback packet
@@ -36,14 +41,24 @@ func (pa *PubackPacket) Reset() {
 	pa.FixedHeader.QoS = byte(0)
 	pa.FixedHeader.RemainingLength = 0
 	pa.FixedHeader.Retain = false
+	pa.FixedHeader.Version = 0
 	pa.MessageID = 0
+	pa.ReasonCode = 0
+	pa.Properties = nil
 }
 
 // SetTraceID will set traceid for tracing
 func (pa *PubackPacket) SetTraceID(id string) { pa.TraceID = id }
 
-// Verify packet availability
-func (pa *PubackPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (pa *PubackPacket) Verify() error {
+	return verifyAck(pa.FixedHeader, pa.MessageID, pa.ReasonCode, pa.Properties)
+}
+
+func (pa *PubackPacket) verifyStrings(check UTF8Check) error {
+	return pa.Properties.verifyStrings(Puback, check)
+}
 
 // SetFixedHeader will set fh for our header
 func (pa *PubackPacket) SetFixedHeader(fh *FixedHeader) {
@@ -62,25 +77,25 @@ func (pa *PubackPacket) String() string {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (pa *PubackPacket) Write(w io.Writer) (n int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	pa.FixedHeader.RemainingLength = 2
-	pa.FixedHeader.pack(b.b[:5])
-
-	if err = encodeUint16(pa.MessageID, b.b[5:]); err != nil {
-		return 0, err
-	}
-	return w.Write(b.b[3:7])
+func (pa *PubackPacket) Write(w io.Writer) (int, error) {
+	n, err := pa.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (pa *PubackPacket) WriteTo(w io.Writer) (int64, error) {
+	return writeAck(w, pa.FixedHeader, pa.MessageID, pa.ReasonCode, pa.Properties)
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (pa *PubackPacket) Size() int {
+	return packetSize(ackLength(pa.FixedHeader, pa.ReasonCode, pa.Properties))
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
 // header has been read
 func (pa *PubackPacket) Unpack(b []byte) (err error) {
-	if len(b) < 2 {
-		return io.ErrShortBuffer
-	}
-	pa.MessageID, err = decodeUint16(b)
+	pa.MessageID, pa.ReasonCode, pa.Properties, err = unpackAck(pa.FixedHeader, b)
 	return err
 }
 
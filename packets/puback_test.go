@@ -31,3 +31,42 @@ func TestPubackPacket(t *testing.T) {
 	assert.Equal(t, pubackPacketBytes.Cap(), ll)
 	packet.Close()
 }
+
+func TestPubackPacketV5(t *testing.T) {
+	cp := NewPubackPacket()
+	cp.SetVersion(MQTT5)
+	cp.MessageID = 1
+	assert.Equal(t, 4, cp.Size())
+	cp.ReasonCode = 0x10
+	assert.Equal(t, 5, cp.Size())
+	cp.Properties = &Properties{ReasonString: "no"}
+	assert.Equal(t, 11, cp.Size())
+	assert.NoError(t, cp.Verify())
+
+	b, err := Marshal(cp)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{64, 9, 0, 1, 0x10, 5, 0x1F, 0, 2, 'n', 'o'}, b)
+
+	cp.ReasonCode = 0x92
+	assert.Error(t, cp.Verify())
+	cp.ReasonCode = 0
+	cp.Properties = &Properties{ContentType: "text"}
+	assert.Error(t, cp.Verify())
+	cp.Close()
+
+	// reason codes are not allowed below the protocol level 5
+	cp = NewPubackPacket()
+	cp.MessageID = 1
+	cp.ReasonCode = 0x10
+	assert.Error(t, cp.Verify())
+	cp.Close()
+
+	// a reason code without properties
+	d := NewDecoder(bytes.NewReader([]byte{64, 3, 0, 1, 0x87}))
+	d.ProtocolVersion = MQTT5
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, ReasonNotAuthorized, packet.(*PubackPacket).ReasonCode)
+	assert.Nil(t, packet.(*PubackPacket).Properties)
+	packet.Close()
+}
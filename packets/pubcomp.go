@@ -18,6 +18,11 @@ type PubcompPacket struct {
 	*FixedHeader
 	MessageID uint16
 	TraceID   string
+
+	// ReasonCode is the reason code of protocol level 5
+	ReasonCode ReasonCode
+	// Properties are the properties of protocol level 5
+	Properties *Properties
 }
 
 // NewPubcompPacket return the ping request packet
@@ -33,8 +38,15 @@ func (pc *PubcompPacket) SetFixedHeader(fh *FixedHeader) {
 // SetTraceID will set traceid for tracing
 func (pc *PubcompPacket) SetTraceID(id string) { pc.TraceID = id }
 
-// Verify packet availability
-func (pc *PubcompPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (pc *PubcompPacket) Verify() error {
+	return verifyAck(pc.FixedHeader, pc.MessageID, pc.ReasonCode, pc.Properties)
+}
+
+func (pc *PubcompPacket) verifyStrings(check UTF8Check) error {
+	return pc.Properties.verifyStrings(Pubcomp, check)
+}
 
 // Type return the packet type
 func (pc *PubcompPacket) Type() byte {
@@ -47,7 +59,10 @@ func (pc *PubcompPacket) Reset() {
 	pc.FixedHeader.QoS = byte(0)
 	pc.FixedHeader.RemainingLength = 0
 	pc.FixedHeader.Retain = false
+	pc.FixedHeader.Version = 0
 	pc.MessageID = 0
+	pc.ReasonCode = 0
+	pc.Properties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
@@ -62,24 +77,25 @@ func (pc *PubcompPacket) String() string {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (pc *PubcompPacket) Write(w io.Writer) (n int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	pc.FixedHeader.RemainingLength = 2
-	pc.FixedHeader.pack(b.b[:5])
-	if err = encodeUint16(pc.MessageID, b.b[5:]); err != nil {
-		return 0, err
-	}
-	return w.Write(b.b[3:7])
+func (pc *PubcompPacket) Write(w io.Writer) (int, error) {
+	n, err := pc.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (pc *PubcompPacket) WriteTo(w io.Writer) (int64, error) {
+	return writeAck(w, pc.FixedHeader, pc.MessageID, pc.ReasonCode, pc.Properties)
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (pc *PubcompPacket) Size() int {
+	return packetSize(ackLength(pc.FixedHeader, pc.ReasonCode, pc.Properties))
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
 // header has been read
 func (pc *PubcompPacket) Unpack(b []byte) (err error) {
-	if len(b) < 2 {
-		return io.ErrShortBuffer
-	}
-	pc.MessageID, err = decodeUint16(b)
+	pc.MessageID, pc.ReasonCode, pc.Properties, err = unpackAck(pc.FixedHeader, b)
 	return err
 }
 
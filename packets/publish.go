@@ -3,7 +3,10 @@ package packets
 import (
 	"fmt"
 	"io"
+	"net"
 	"sync"
+
+	"github.com/arthurkiller/mqtgo/topic"
 )
 
 var _publishPacketPool = sync.Pool{
@@ -20,6 +23,18 @@ type PublishPacket struct {
 	MessageID uint16
 	Payload   []byte
 	TraceID   string
+
+	// Properties are the properties of protocol level 5
+	Properties *Properties
+
+	// PayloadReader streams the payload instead of Payload when it is not
+	// nil, PayloadLength bytes will be read from it. Packets decoded by
+	// Decoder.DecodePublishStream read the payload from the connection.
+	PayloadReader io.Reader
+	PayloadLength int
+
+	// frame holds the buffer aliased by a zero-copy decoded packet
+	frame *frame
 }
 
 // NewPublishPacket return the ping request packet
@@ -35,8 +50,43 @@ func (p *PublishPacket) SetFixedHeader(fh *FixedHeader) {
 // SetTraceID will set traceid for tracing
 func (p *PublishPacket) SetTraceID(id string) { p.TraceID = id }
 
-// Verify packet availability
-func (p *PublishPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (p *PublishPacket) Verify() error {
+	if p.QoS > 2 {
+		return verifyError(Publish, "MQTT-3.3.1-4", "invalid QoS %d", p.QoS)
+	}
+	if p.QoS == 0 && p.Dup {
+		return verifyError(Publish, "MQTT-3.3.1-2", "DUP flag set on QoS 0 message")
+	}
+	if p.QoS > 0 {
+		if err := verifyMessageID(Publish, p.MessageID); err != nil {
+			return err
+		}
+	}
+	if err := verifyPacketProperties(p.FixedHeader, p.Properties); err != nil {
+		return err
+	}
+	if err := p.verifyTopics(&specTopics); err != nil {
+		return err
+	}
+	return p.verifyStrings(UTF8CheckSpec)
+}
+
+func (p *PublishPacket) verifyTopics(v *topic.Validator) error {
+	// a topic alias stands for the topic name on protocol level 5
+	if len(p.TopicName) == 0 && p.version() == MQTT5 && p.Properties != nil && p.Properties.TopicAlias != nil {
+		return nil
+	}
+	return verifyTopicName(Publish, v, p.TopicName)
+}
+
+func (p *PublishPacket) verifyStrings(check UTF8Check) error {
+	if err := verifyString(Publish, "topic name", p.TopicName, check); err != nil {
+		return err
+	}
+	return p.Properties.verifyStrings(Publish, check)
+}
 
 // Type return the packet type
 func (p *PublishPacket) Type() byte {
@@ -49,14 +99,22 @@ func (p *PublishPacket) Reset() {
 	p.FixedHeader.QoS = byte(0)
 	p.FixedHeader.RemainingLength = 0
 	p.FixedHeader.Retain = false
+	p.FixedHeader.Version = 0
 	p.TopicName = ""
 	p.MessageID = 0
 	p.Payload = []byte{}
+	p.PayloadReader = nil
+	p.PayloadLength = 0
+	p.Properties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
 func (p *PublishPacket) Close() {
 	p.Reset()
+	if p.frame != nil {
+		putFrame(p.frame)
+		p.frame = nil
+	}
 	_publishPacketPool.Put(p)
 }
 
@@ -67,68 +125,151 @@ func (p *PublishPacket) String() string {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (p *PublishPacket) Write(w io.Writer) (n int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
+func (p *PublishPacket) Write(w io.Writer) (int, error) {
+	n, err := p.WriteTo(w)
+	return int(n), err
+}
 
-	n = len(p.TopicName) + 2
-	pb := make([]byte, n+2)
-	if err = encodeString(p.TopicName, pb[:n]); err != nil {
+// WriteTo writes the packet into w with a single Write. The fixed header and
+// variable header are built in one buffer, which is sent along with the
+// payload through net.Buffers to the connections of the net package, so they
+// emit the whole packet with a single writev. The payload is copied after
+// the headers for the other writers, such as a tls.Conn or a bufio.Writer.
+func (p *PublishPacket) WriteTo(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header
+	n := 5 + len(p.TopicName) + 2
+	size := n + 2 + packetPropertiesSize(p.FixedHeader, p.Properties)
+	vectored := writev(w)
+	if !vectored && p.PayloadReader == nil {
+		size += len(p.Payload)
+	}
+	pb := getBuf(size)
+	defer putBuf(pb)
+	if err := encodeString(p.TopicName, pb[5:n]); err != nil {
 		return 0, err
 	}
 
 	if p.QoS > 0 {
-		if err = encodeUint16(p.MessageID, pb[n:]); err != nil {
+		if err := encodeUint16(p.MessageID, pb[n:]); err != nil {
 			return 0, err
 		}
 		n += 2
 	}
-	p.FixedHeader.RemainingLength = n + len(p.Payload)
-	m := p.FixedHeader.pack(b.b[:5])
+	if p.version() == MQTT5 {
+		m, err := p.Properties.Pack(pb[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
+	if p.PayloadReader != nil {
+		return p.writeStream(w, pb, n)
+	}
+	p.FixedHeader.RemainingLength = n - 5 + len(p.Payload)
+	m := p.FixedHeader.pack(pb[:5])
+	if m == 0 {
+		return 0, ErrMQTTPacketLimitation
+	}
 
-	if m, err = w.Write(b.b[5-m : 5]); err != nil {
-		return m, err
+	if len(p.Payload) == 0 || !vectored {
+		// pb holds the payload, the append does not allocate
+		z, err := w.Write(append(pb[5-m:n], p.Payload...))
+		return int64(z), err
 	}
+	bufs := net.Buffers{pb[5-m : n], p.Payload}
+	return bufs.WriteTo(w)
+}
 
-	if n, err = w.Write(pb[:n]); err != nil {
-		return n + m, err
+// writev reports whether net.Buffers writes to w with a single writev, it
+// makes one Write per buffer on the writers out of the net package
+func writev(w io.Writer) bool {
+	switch w.(type) {
+	case *net.TCPConn, *net.UnixConn, *net.IPConn:
+		return true
 	}
+	return false
+}
 
-	// TODO FIXME XXX should be bufferd?
-	// should this be in one Write?
-	z, err := w.Write(p.Payload)
-	return n + m + z, err
+// writeStream writes the headers in pb[:n] and copies the payload from
+// PayloadReader, so the payload is never held in memory as a whole
+func (p *PublishPacket) writeStream(w io.Writer, pb []byte, n int) (int64, error) {
+	p.FixedHeader.RemainingLength = n - 5 + p.PayloadLength
+	m := p.FixedHeader.pack(pb[:5])
+	if m == 0 {
+		return 0, ErrMQTTPacketLimitation
+	}
+	z, err := w.Write(pb[5-m : n])
+	if err != nil {
+		return int64(z), err
+	}
+	c, err := io.CopyN(w, p.PayloadReader, int64(p.PayloadLength))
+	return int64(z) + c, err
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (p *PublishPacket) Size() int {
+	rl := 2 + len(p.TopicName) + len(p.Payload)
+	if p.PayloadReader != nil {
+		rl = 2 + len(p.TopicName) + p.PayloadLength
+	}
+	if p.QoS > 0 {
+		rl += 2
+	}
+	return packetSize(rl + packetPropertiesSize(p.FixedHeader, p.Properties))
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
 // header has been read
 func (p *PublishPacket) Unpack(b []byte) error {
+	return p.unpack(b, false)
+}
+
+// unpackFrame decodes the packet with TopicName and Payload aliasing b,
+// f owning b will be released on Close
+func (p *PublishPacket) unpackFrame(b []byte, f *frame) error {
+	p.frame = f
+	return p.unpack(b, true)
+}
+
+func (p *PublishPacket) unpack(b []byte, alias bool) error {
 	var (
 		n   int
 		err error
 	)
 	var payloadLength = p.FixedHeader.RemainingLength
 
-	p.TopicName, n, err = decodeString(b)
+	str := decodeString
+	if alias {
+		str = decodeStringNoCopy
+	}
+	p.TopicName, n, err = str(b)
 	if err != nil {
-		return err
+		return malformed(p.FixedHeader, 0, "truncated topic name")
 	}
 	if p.QoS > 0 {
 		p.MessageID, err = decodeUint16(b[n:])
+		if err != nil {
+			return malformed(p.FixedHeader, n, "truncated packet identifier")
+		}
 		n += 2
-		payloadLength -= len(p.TopicName) + 4
-	} else {
-		payloadLength -= len(p.TopicName) + 2
 	}
-
-	if err != nil {
-		return err
+	if p.version() == MQTT5 {
+		var m int
+		p.Properties, m, err = unpackProperties(p.FixedHeader, b, n, Publish)
+		if err != nil {
+			return err
+		}
+		n += m
 	}
+	payloadLength -= n
 
-	if payloadLength < 0 {
-		return fmt.Errorf("Error upacking publish, payload length < 0")
+	if payloadLength < 0 || len(b) < n+payloadLength {
+		return malformed(p.FixedHeader, len(b), "remaining length shorter than variable header")
+	}
+	if alias {
+		p.Payload = b[n : n+payloadLength]
+		return nil
 	}
-
 	p.Payload = make([]byte, payloadLength)
 	copy(p.Payload, b[n:])
 	return nil
@@ -138,11 +279,20 @@ func (p *PublishPacket) Unpack(b []byte) error {
 // but an empty fixed header, useful for when you want to deliver
 // a message with different properties such as QoS but the same
 // content
-// XXX need to check if put back a packet will cause noting wrong
+// The protocol level is kept, the topic, the payload and the properties are
+// deep copied, so the copy stays valid after p is closed and never aliases
+// the buffer of a zero-copy decoded packet, UnmarshalNoCopy included. A
+// streamed payload can not be copied: Copy of a packet with a PayloadReader
+// returns nil, read the payload into Payload first.
 func (p *PublishPacket) Copy() *PublishPacket {
+	if p.PayloadReader != nil {
+		return nil
+	}
 	newP := NewControlPacket(Publish).(*PublishPacket)
-	newP.TopicName = p.TopicName
-	newP.Payload = p.Payload
+	newP.Version = p.Version
+	newP.TopicName = string([]byte(p.TopicName))
+	newP.Payload = append([]byte(nil), p.Payload...)
+	newP.Properties = p.Properties.Copy()
 
 	return newP
 }
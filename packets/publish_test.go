@@ -2,6 +2,9 @@ package packets
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -77,3 +80,152 @@ func TestPublishPacket(t *testing.T) {
 
 	packet.Close()
 }
+
+func TestPublishPacketStream(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+
+	cp := NewPublishPacket()
+	cp.FixedHeader.QoS = 1
+	cp.TopicName = "fw/image"
+	cp.MessageID = 1234
+	cp.PayloadReader = bytes.NewReader(payload)
+	cp.PayloadLength = len(payload)
+	assert.Equal(t, 3+2+8+2+len(payload), cp.Size())
+
+	stream := bytes.Buffer{}
+	e := NewEncoder(&stream)
+	assert.NoError(t, e.Encode(cp))
+	assert.Equal(t, cp.Size(), stream.Len())
+	cp.Close()
+	// a ping after the publish makes sure an unread payload is skipped
+	stream.Write([]byte{192, 0})
+	stream.Write(stream.Bytes())
+
+	d := NewDecoder(&stream)
+	d.StreamThreshold = 1024
+	d.MaxSize = 1024
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	p := packet.(*PublishPacket)
+	assert.Equal(t, "fw/image", p.TopicName)
+	assert.Equal(t, uint16(1234), p.MessageID)
+	assert.Equal(t, len(payload), p.PayloadLength)
+	read, err := ioutil.ReadAll(p.PayloadReader)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, read)
+	p.Close()
+
+	packet, err = d.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(Pingreq), packet.Type())
+
+	packet, err = d.Decode()
+	assert.NoError(t, err)
+	p = packet.(*PublishPacket)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(p.PayloadReader, buf)
+	assert.NoError(t, err)
+	p.Close()
+
+	packet, err = d.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(Pingreq), packet.Type())
+}
+
+func TestPublishPacketV5(t *testing.T) {
+	alias := uint16(3)
+	cp := NewPublishPacket()
+	cp.SetVersion(MQTT5)
+	cp.QoS = 1
+	cp.MessageID = 7
+	cp.Properties = &Properties{TopicAlias: &alias}
+	cp.Payload = []byte("hi")
+	// the topic alias stands for the empty topic name
+	assert.NoError(t, cp.Verify())
+	cp.Properties = nil
+	assert.Error(t, cp.Verify())
+	cp.TopicName = "a"
+	cp.Properties = &Properties{SessionExpiry: new(uint32)}
+	assert.Error(t, cp.Verify())
+	cp.Properties = &Properties{ContentType: "text/plain"}
+	assert.NoError(t, cp.Verify())
+
+	stream := bytes.Buffer{}
+	e := NewEncoder(&stream)
+	e.ProtocolVersion = MQTT5
+	assert.NoError(t, e.Encode(cp))
+	assert.Equal(t, cp.Size(), stream.Len())
+	cp.Close()
+	stream.Write(stream.Bytes())
+
+	for _, threshold := range []int{0, 1} {
+		d := NewDecoder(&stream)
+		d.ProtocolVersion = MQTT5
+		d.StreamThreshold = threshold
+		packet, err := d.Decode()
+		assert.NoError(t, err)
+		p := packet.(*PublishPacket)
+		assert.Equal(t, "a", p.TopicName)
+		assert.Equal(t, uint16(7), p.MessageID)
+		assert.Equal(t, "text/plain", p.Properties.ContentType)
+		if threshold > 0 {
+			read, err := ioutil.ReadAll(p.PayloadReader)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("hi"), read)
+		} else {
+			assert.Equal(t, []byte("hi"), p.Payload)
+		}
+		p.Close()
+	}
+
+	// the properties of a streamed PUBLISH are bounded by MaxSize
+	d := NewDecoder(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0x7f, 0, 1, 'a', 0xf0, 0xff, 0xff, 0x7f}))
+	d.ProtocolVersion = MQTT5
+	d.MaxSize = 1024
+	d.StreamThreshold = 16
+	_, err := d.Decode()
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+
+	// properties are not allowed below the protocol level 5
+	cp = NewPublishPacket()
+	cp.TopicName = "a"
+	cp.Properties = &Properties{ContentType: "text/plain"}
+	assert.Error(t, cp.Verify())
+	cp.Close()
+}
+
+func TestPublishPacketCopy(t *testing.T) {
+	alias := uint16(3)
+	p := NewPublishPacket()
+	p.SetVersion(MQTT5)
+	p.TopicName = "a"
+	p.Payload = []byte("hi")
+	p.Properties = &Properties{TopicAlias: &alias, CorrelationData: []byte{1}, User: []UserProperty{{"k", "v"}}}
+	c := p.Copy()
+	assert.Equal(t, MQTT5, c.Version)
+	assert.Equal(t, p.Properties, c.Properties)
+	*p.Properties.TopicAlias = 4
+	p.Properties.CorrelationData[0] = 2
+	p.Properties.User[0].Value = "w"
+	assert.Equal(t, uint16(3), *c.Properties.TopicAlias)
+	assert.Equal(t, []byte{1}, c.Properties.CorrelationData)
+	assert.Equal(t, "v", c.Properties.User[0].Value)
+	c.Close()
+
+	// the copy of a packet aliasing the caller's buffer does not alias it
+	data := []byte{48, 5, 0, 1, 97, 104, 105}
+	cp, _, err := UnmarshalNoCopy(data)
+	if assert.NoError(t, err) {
+		c = cp.(*PublishPacket).Copy()
+		copy(data, make([]byte, len(data)))
+		assert.Equal(t, "a", c.TopicName)
+		assert.Equal(t, []byte("hi"), c.Payload)
+		c.Close()
+		cp.Close()
+	}
+
+	// a streamed payload can not be copied
+	p.PayloadReader, p.PayloadLength = bytes.NewReader([]byte("hi")), 2
+	assert.Nil(t, p.Copy())
+	p.Close()
+}
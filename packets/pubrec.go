@@ -18,6 +18,11 @@ type PubrecPacket struct {
 	*FixedHeader
 	MessageID uint16
 	TraceID   string
+
+	// ReasonCode is the reason code of protocol level 5
+	ReasonCode ReasonCode
+	// Properties are the properties of protocol level 5
+	Properties *Properties
 }
 
 // NewPubrecPacket return the ping request packet
@@ -33,8 +38,15 @@ func (pr *PubrecPacket) SetFixedHeader(fh *FixedHeader) {
 // SetTraceID will set traceid for tracing
 func (pr *PubrecPacket) SetTraceID(id string) { pr.TraceID = id }
 
-// Verify packet availability
-func (pr *PubrecPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (pr *PubrecPacket) Verify() error {
+	return verifyAck(pr.FixedHeader, pr.MessageID, pr.ReasonCode, pr.Properties)
+}
+
+func (pr *PubrecPacket) verifyStrings(check UTF8Check) error {
+	return pr.Properties.verifyStrings(Pubrec, check)
+}
 
 // Type return the packet type
 func (pr *PubrecPacket) Type() byte {
@@ -47,7 +59,10 @@ func (pr *PubrecPacket) Reset() {
 	pr.FixedHeader.QoS = byte(0)
 	pr.FixedHeader.RemainingLength = 0
 	pr.FixedHeader.Retain = false
+	pr.FixedHeader.Version = 0
 	pr.MessageID = 0
+	pr.ReasonCode = 0
+	pr.Properties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
@@ -62,24 +77,25 @@ func (pr *PubrecPacket) String() string {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (pr *PubrecPacket) Write(w io.Writer) (n int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	pr.FixedHeader.RemainingLength = 2
-	pr.FixedHeader.pack(b.b[:5])
-	if err = encodeUint16(pr.MessageID, b.b[5:]); err != nil {
-		return 0, err
-	}
-	return w.Write(b.b[3:7])
+func (pr *PubrecPacket) Write(w io.Writer) (int, error) {
+	n, err := pr.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (pr *PubrecPacket) WriteTo(w io.Writer) (int64, error) {
+	return writeAck(w, pr.FixedHeader, pr.MessageID, pr.ReasonCode, pr.Properties)
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (pr *PubrecPacket) Size() int {
+	return packetSize(ackLength(pr.FixedHeader, pr.ReasonCode, pr.Properties))
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
 // header has been read
 func (pr *PubrecPacket) Unpack(b []byte) (err error) {
-	if len(b) < 2 {
-		return io.ErrShortBuffer
-	}
-	pr.MessageID, err = decodeUint16(b)
+	pr.MessageID, pr.ReasonCode, pr.Properties, err = unpackAck(pr.FixedHeader, b)
 	return err
 }
 
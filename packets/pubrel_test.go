@@ -2,6 +2,7 @@ package packets
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -31,3 +32,29 @@ func TestPubrelPacket(t *testing.T) {
 	assert.Equal(t, uint16(1234), cp.MessageID, "Pubrel messageID")
 	packet.Close()
 }
+
+func TestPubrelPacketV5(t *testing.T) {
+	cp := NewPubrelPacket()
+	cp.SetVersion(MQTT5)
+	cp.MessageID = 2
+	cp.ReasonCode = 0x92
+	assert.NoError(t, cp.Verify())
+	cp.ReasonCode = 0x10
+	assert.Error(t, cp.Verify())
+	cp.Close()
+
+	d := NewDecoder(bytes.NewReader([]byte{98, 7, 0, 2, 0x92, 3, 0x1F, 0, 0}))
+	d.ProtocolVersion = MQTT5
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	pr := packet.(*PubrelPacket)
+	assert.Equal(t, ReasonPacketIdentifierNotFound, pr.ReasonCode)
+	assert.NotNil(t, pr.Properties)
+	packet.Close()
+
+	// the properties must not run past the packet
+	d = NewDecoder(bytes.NewReader([]byte{98, 4, 0, 2, 0x92, 3}))
+	d.ProtocolVersion = MQTT5
+	_, err = d.Decode()
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+}
@@ -0,0 +1,324 @@
+package packets
+
+import "fmt"
+
+// ReasonCode is a reason code of protocol level 5, the result of an operation
+// carried by CONNACK, the acknowledgements, DISCONNECT and AUTH. The return
+// codes of CONNACK and SUBACK on the levels below are registered as well.
+type ReasonCode byte
+
+// Below are the reason codes of protocol level 5, the codes of value 0x80 or
+// more are errors. Success is Normal disconnection in DISCONNECT and Granted
+// QoS 0 in SUBACK.
+const (
+	ReasonSuccess                             ReasonCode = 0x00
+	ReasonGrantedQoS1                         ReasonCode = 0x01
+	ReasonGrantedQoS2                         ReasonCode = 0x02
+	ReasonDisconnectWithWill                  ReasonCode = 0x04
+	ReasonNoMatchingSubscribers               ReasonCode = 0x10
+	ReasonNoSubscriptionExisted               ReasonCode = 0x11
+	ReasonContinueAuthentication              ReasonCode = 0x18
+	ReasonReauthenticate                      ReasonCode = 0x19
+	ReasonUnspecifiedError                    ReasonCode = 0x80
+	ReasonMalformedPacket                     ReasonCode = 0x81
+	ReasonProtocolError                       ReasonCode = 0x82
+	ReasonImplementationSpecificError         ReasonCode = 0x83
+	ReasonUnsupportedProtocolVersion          ReasonCode = 0x84
+	ReasonClientIdentifierNotValid            ReasonCode = 0x85
+	ReasonBadUsernameOrPassword               ReasonCode = 0x86
+	ReasonNotAuthorized                       ReasonCode = 0x87
+	ReasonServerUnavailable                   ReasonCode = 0x88
+	ReasonServerBusy                          ReasonCode = 0x89
+	ReasonBanned                              ReasonCode = 0x8A
+	ReasonServerShuttingDown                  ReasonCode = 0x8B
+	ReasonBadAuthenticationMethod             ReasonCode = 0x8C
+	ReasonKeepAliveTimeout                    ReasonCode = 0x8D
+	ReasonSessionTakenOver                    ReasonCode = 0x8E
+	ReasonTopicFilterInvalid                  ReasonCode = 0x8F
+	ReasonTopicNameInvalid                    ReasonCode = 0x90
+	ReasonPacketIdentifierInUse               ReasonCode = 0x91
+	ReasonPacketIdentifierNotFound            ReasonCode = 0x92
+	ReasonReceiveMaximumExceeded              ReasonCode = 0x93
+	ReasonTopicAliasInvalid                   ReasonCode = 0x94
+	ReasonPacketTooLarge                      ReasonCode = 0x95
+	ReasonMessageRateTooHigh                  ReasonCode = 0x96
+	ReasonQuotaExceeded                       ReasonCode = 0x97
+	ReasonAdministrativeAction                ReasonCode = 0x98
+	ReasonPayloadFormatInvalid                ReasonCode = 0x99
+	ReasonRetainNotSupported                  ReasonCode = 0x9A
+	ReasonQoSNotSupported                     ReasonCode = 0x9B
+	ReasonUseAnotherServer                    ReasonCode = 0x9C
+	ReasonServerMoved                         ReasonCode = 0x9D
+	ReasonSharedSubscriptionsNotSupported     ReasonCode = 0x9E
+	ReasonConnectionRateExceeded              ReasonCode = 0x9F
+	ReasonMaximumConnectTime                  ReasonCode = 0xA0
+	ReasonSubscriptionIdentifiersNotSupported ReasonCode = 0xA1
+	ReasonWildcardSubscriptionsNotSupported   ReasonCode = 0xA2
+)
+
+// ReasonCodeInfo describes a reason code carried by some packet types on a
+// protocol level
+type ReasonCodeInfo struct {
+	Code ReasonCode
+	// Name is the name of the code in the specification
+	Name string
+	// Description tells when the code is used
+	Description string
+	// Packets are the packet types carrying the code under this name
+	Packets []byte
+	// Version is MQTT5 for the reason codes and MQTT311 for the return
+	// codes of the levels below
+	Version byte
+	// Error reports whether the code tells a failure
+	Error bool
+}
+
+// Below are the packet types carrying the most common reason codes
+var (
+	acks       = []byte{Connack, Puback, Pubrec, Suback, Unsuback, Disconnect}
+	publishAck = []byte{Puback, Pubrec}
+	connectAck = []byte{Connack, Disconnect}
+)
+
+// reasonCodes is the registry of the reason codes
+var reasonCodes = []ReasonCodeInfo{
+	reason(ReasonSuccess, "Success", "The operation succeeded.",
+		Connack, Puback, Pubrec, Pubrel, Pubcomp, Unsuback, Auth),
+	reason(ReasonSuccess, "Normal disconnection", "Close the connection normally, do not send the Will Message.",
+		Disconnect),
+	reason(ReasonSuccess, "Granted QoS 0", "The subscription is accepted with a maximum QoS of 0.", Suback),
+	reason(ReasonGrantedQoS1, "Granted QoS 1", "The subscription is accepted with a maximum QoS of 1.", Suback),
+	reason(ReasonGrantedQoS2, "Granted QoS 2", "The subscription is accepted with a maximum QoS of 2.", Suback),
+	reason(ReasonDisconnectWithWill, "Disconnect with Will Message",
+		"The client wishes to disconnect but requires the server to publish its Will Message.", Disconnect),
+	reason(ReasonNoMatchingSubscribers, "No matching subscribers",
+		"The message is accepted but there are no subscribers.", publishAck...),
+	reason(ReasonNoSubscriptionExisted, "No subscription existed", "No matching topic filter is being used.", Unsuback),
+	reason(ReasonContinueAuthentication, "Continue authentication", "Continue the authentication with another step.", Auth),
+	reason(ReasonReauthenticate, "Re-authenticate", "Initiate a re-authentication.", Auth),
+	reason(ReasonUnspecifiedError, "Unspecified error",
+		"The sender does not wish to reveal the reason, or none of the other codes apply.", acks...),
+	reason(ReasonMalformedPacket, "Malformed Packet",
+		"A packet could not be parsed correctly according to the specification.", connectAck...),
+	reason(ReasonProtocolError, "Protocol Error",
+		"A packet contained data not allowed by the protocol or inconsistent with the state.", connectAck...),
+	reason(ReasonImplementationSpecificError, "Implementation specific error",
+		"The packet is valid but not accepted by the implementation of the receiver.", acks...),
+	reason(ReasonUnsupportedProtocolVersion, "Unsupported Protocol Version",
+		"The server does not support the requested protocol level.", Connack),
+	reason(ReasonClientIdentifierNotValid, "Client Identifier not valid",
+		"The client identifier is a valid string but not allowed by the server.", Connack),
+	reason(ReasonBadUsernameOrPassword, "Bad User Name or Password",
+		"The server does not accept the user name or password.", Connack),
+	reason(ReasonNotAuthorized, "Not authorized", "The operation is not authorized.", acks...),
+	reason(ReasonServerUnavailable, "Server unavailable", "The server is not available.", Connack),
+	reason(ReasonServerBusy, "Server busy", "The server is busy, try again later.", connectAck...),
+	reason(ReasonBanned, "Banned", "The client has been banned by administrative action.", Connack),
+	reason(ReasonServerShuttingDown, "Server shutting down", "The server is shutting down.", Disconnect),
+	reason(ReasonBadAuthenticationMethod, "Bad authentication method",
+		"The authentication method is not supported or does not match the one in use.", connectAck...),
+	reason(ReasonKeepAliveTimeout, "Keep Alive timeout",
+		"No packet has been received for 1.5 times the keepalive.", Disconnect),
+	reason(ReasonSessionTakenOver, "Session taken over",
+		"Another connection using the same client identifier has connected.", Disconnect),
+	reason(ReasonTopicFilterInvalid, "Topic Filter invalid",
+		"The topic filter is well formed but not accepted.", Suback, Unsuback, Disconnect),
+	reason(ReasonTopicNameInvalid, "Topic Name invalid",
+		"The topic name is well formed but not accepted.", Connack, Puback, Pubrec, Disconnect),
+	reason(ReasonPacketIdentifierInUse, "Packet Identifier in use",
+		"The packet identifier is already in use.", Puback, Pubrec, Suback, Unsuback),
+	reason(ReasonPacketIdentifierNotFound, "Packet Identifier not found",
+		"The packet identifier is not known.", Pubrel, Pubcomp),
+	reason(ReasonReceiveMaximumExceeded, "Receive Maximum exceeded",
+		"More publications than the receive maximum are unacknowledged.", Disconnect),
+	reason(ReasonTopicAliasInvalid, "Topic Alias invalid",
+		"The topic alias is 0 or greater than the topic alias maximum.", Disconnect),
+	reason(ReasonPacketTooLarge, "Packet too large",
+		"The packet exceeded the maximum permissible size.", connectAck...),
+	reason(ReasonMessageRateTooHigh, "Message rate too high", "The received data rate is too high.", Disconnect),
+	reason(ReasonQuotaExceeded, "Quota exceeded", "An implementation or administrative imposed limit has been exceeded.",
+		Connack, Puback, Pubrec, Suback, Disconnect),
+	reason(ReasonAdministrativeAction, "Administrative action",
+		"The connection is closed due to an administrative action.", Disconnect),
+	reason(ReasonPayloadFormatInvalid, "Payload format invalid",
+		"The payload does not match the payload format indicator.", Connack, Puback, Pubrec, Disconnect),
+	reason(ReasonRetainNotSupported, "Retain not supported", "The server does not support retained messages.",
+		connectAck...),
+	reason(ReasonQoSNotSupported, "QoS not supported", "The QoS is greater than the maximum QoS of the server.",
+		connectAck...),
+	reason(ReasonUseAnotherServer, "Use another server", "The client should temporarily use another server.",
+		connectAck...),
+	reason(ReasonServerMoved, "Server moved", "The client should permanently use another server.", connectAck...),
+	reason(ReasonSharedSubscriptionsNotSupported, "Shared Subscriptions not supported",
+		"The server does not support shared subscriptions.", Suback, Disconnect),
+	reason(ReasonConnectionRateExceeded, "Connection rate exceeded",
+		"The connection rate limit has been exceeded.", connectAck...),
+	reason(ReasonMaximumConnectTime, "Maximum connect time",
+		"The maximum connection time authorized for this connection has been exceeded.", Disconnect),
+	reason(ReasonSubscriptionIdentifiersNotSupported, "Subscription Identifiers not supported",
+		"The server does not support subscription identifiers.", Suback, Disconnect),
+	reason(ReasonWildcardSubscriptionsNotSupported, "Wildcard Subscriptions not supported",
+		"The server does not support wildcard subscriptions.", Suback, Disconnect),
+
+	returnCode(Accepted, Connack, "Connection Accepted", "The connection is accepted."),
+	returnCode(ErrRefusedBadProtocolVersion, Connack, "Connection Refused, unacceptable protocol version",
+		"The server does not support the requested protocol level."),
+	returnCode(ErrRefusedIDRejected, Connack, "Connection Refused, identifier rejected",
+		"The client identifier is correct UTF-8 but not allowed by the server."),
+	returnCode(ErrRefusedServerUnavailable, Connack, "Connection Refused, Server unavailable",
+		"The network connection has been made but the MQTT service is unavailable."),
+	returnCode(ErrRefusedBadUsernameOrPassword, Connack, "Connection Refused, bad user name or password",
+		"The data in the user name or password is malformed."),
+	returnCode(ErrRefusedNotAuthorised, Connack, "Connection Refused, not authorized",
+		"The client is not authorized to connect."),
+	returnCode(0x00, Suback, "Success - Maximum QoS 0", "The subscription is accepted with a maximum QoS of 0."),
+	returnCode(0x01, Suback, "Success - Maximum QoS 1", "The subscription is accepted with a maximum QoS of 1."),
+	returnCode(0x02, Suback, "Success - Maximum QoS 2", "The subscription is accepted with a maximum QoS of 2."),
+	returnCode(Failure, Suback, "Failure", "The subscription is refused."),
+}
+
+// reason returns the registry entry of a reason code of protocol level 5
+func reason(code ReasonCode, name, description string, packets ...byte) ReasonCodeInfo {
+	return ReasonCodeInfo{
+		Code:        code,
+		Name:        name,
+		Description: description,
+		Packets:     packets,
+		Version:     MQTT5,
+		Error:       code >= 0x80,
+	}
+}
+
+// returnCode returns the registry entry of a return code of the protocol
+// levels below 5
+func returnCode(code byte, packetType byte, name, description string) ReasonCodeInfo {
+	return ReasonCodeInfo{
+		Code:        ReasonCode(code),
+		Name:        name,
+		Description: description,
+		Packets:     []byte{packetType},
+		Version:     MQTT311,
+		Error:       code != 0 && (packetType != Suback || code >= 0x80),
+	}
+}
+
+// reasonCodeKey is the key of the entries of reasonCodeIndex
+type reasonCodeKey struct {
+	code       ReasonCode
+	packetType byte
+	version    byte
+}
+
+// reasonCodeIndex indexes the registry by code, packet type and level
+var reasonCodeIndex = func() map[reasonCodeKey]int {
+	index := make(map[reasonCodeKey]int)
+	for i, info := range reasonCodes {
+		for _, packetType := range info.Packets {
+			index[reasonCodeKey{info.Code, packetType, info.Version}] = i
+		}
+	}
+	return index
+}()
+
+// Info returns the registry entry of r carried by a packet of packetType on
+// the protocol level version, the levels below 5 share the return codes of
+// MQTT311. ok reports whether the packet type may carry r on the level.
+func (r ReasonCode) Info(packetType, version byte) (info ReasonCodeInfo, ok bool) {
+	if version != MQTT5 {
+		version = MQTT311
+	}
+	i, ok := reasonCodeIndex[reasonCodeKey{r, packetType, version}]
+	if !ok {
+		return ReasonCodeInfo{}, false
+	}
+	return reasonCodes[i], true
+}
+
+// Valid reports whether a packet of packetType may carry r on the protocol
+// level version
+func (r ReasonCode) Valid(packetType, version byte) bool {
+	_, ok := r.Info(packetType, version)
+	return ok
+}
+
+// IsError reports whether r tells a failure on protocol level 5
+func (r ReasonCode) IsError() bool {
+	return r >= 0x80
+}
+
+// ReasonError is the error form of a reason code telling a failure, see Err
+type ReasonError ReasonCode
+
+func (e ReasonError) Error() string {
+	return ReasonCode(e).String()
+}
+
+// Code returns the reason code of the error
+func (e ReasonError) Code() ReasonCode { return ReasonCode(e) }
+
+// Err returns the error form of r, nil if r does not tell a failure. The
+// errors compare equal for equal reason codes, so errors.Is tests them. The
+// CONNACK return codes of protocol level 3.1.1 get theirs with ReasonCodeOf.
+func (r ReasonCode) Err() error {
+	if !r.IsError() {
+		return nil
+	}
+	return ReasonError(r)
+}
+
+// String returns the name of r on protocol level 5
+func (r ReasonCode) String() string {
+	for _, info := range reasonCodes {
+		if info.Code == r && info.Version == MQTT5 {
+			return info.Name
+		}
+	}
+	return fmt.Sprintf("reason code 0x%02X", byte(r))
+}
+
+// ReturnCode returns the return code of protocol level 3.1.1 closest to the
+// reason code r of a CONNACK or a SUBACK of protocol level 5, the CONNACK
+// errors without counterpart are Server unavailable. Other packet types
+// carry no return code and get r unchanged.
+func (r ReasonCode) ReturnCode(packetType byte) byte {
+	switch {
+	case packetType == Suback && r.IsError():
+		return Failure
+	case packetType != Connack || r == ReasonSuccess:
+		return byte(r)
+	}
+	for code, v5 := range connackReasonCodes {
+		if v5 == r && code <= ErrRefusedNotAuthorised {
+			return code
+		}
+	}
+	switch r {
+	case ReasonBanned, ReasonBadAuthenticationMethod:
+		return ErrRefusedNotAuthorised
+	}
+	return ErrRefusedServerUnavailable
+}
+
+// ReasonCodeOf returns the reason code of protocol level 5 closest to the
+// return code of a CONNACK or a SUBACK of protocol level 3.1.1. Unknown
+// CONNACK return codes are an Unspecified error.
+func ReasonCodeOf(packetType, returnCode byte) ReasonCode {
+	if packetType != Connack || returnCode == Accepted {
+		return ReasonCode(returnCode)
+	}
+	if r, ok := connackReasonCodes[returnCode]; ok {
+		return r
+	}
+	return ReasonUnspecifiedError
+}
+
+// connackReasonCodes maps the CONNACK return codes, the error codes of
+// Connect() included, to the reason codes of the protocol level 5
+var connackReasonCodes = map[byte]ReasonCode{
+	ErrRefusedBadProtocolVersion:    ReasonUnsupportedProtocolVersion,
+	ErrRefusedIDRejected:            ReasonClientIdentifierNotValid,
+	ErrRefusedServerUnavailable:     ReasonServerUnavailable,
+	ErrRefusedBadUsernameOrPassword: ReasonBadUsernameOrPassword,
+	ErrRefusedNotAuthorised:         ReasonNotAuthorized,
+	ErrProtocolViolation:            ReasonProtocolError,
+}
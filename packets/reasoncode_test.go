@@ -0,0 +1,108 @@
+package packets
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReasonCode(t *testing.T) {
+	info, ok := ReasonSuccess.Info(Disconnect, MQTT5)
+	assert.True(t, ok)
+	assert.Equal(t, "Normal disconnection", info.Name)
+	assert.False(t, info.Error)
+	info, ok = ReasonSuccess.Info(Suback, MQTT5)
+	assert.True(t, ok)
+	assert.Equal(t, "Granted QoS 0", info.Name)
+	assert.Equal(t, "Success", ReasonSuccess.String())
+
+	info, ok = ReasonCode(ErrRefusedIDRejected).Info(Connack, MQTT31)
+	assert.True(t, ok)
+	assert.Equal(t, byte(MQTT311), info.Version)
+	assert.True(t, info.Error)
+	info, ok = ReasonCode(Failure).Info(Suback, MQTT311)
+	assert.True(t, ok)
+	assert.True(t, info.Error)
+
+	assert.True(t, ReasonQuotaExceeded.Valid(Pubrec, MQTT5))
+	assert.False(t, ReasonQuotaExceeded.Valid(Pubrel, MQTT5))
+	assert.False(t, ReasonGrantedQoS1.Valid(Connack, MQTT5))
+	assert.True(t, ReasonGrantedQoS1.Valid(Connack, MQTT311))
+	assert.True(t, ReasonBanned.IsError())
+	assert.False(t, ReasonNoMatchingSubscribers.IsError())
+	assert.Equal(t, "reason code 0x03", ReasonCode(3).String())
+
+	// every entry of the registry is reachable
+	for _, info := range reasonCodes {
+		for _, packetType := range info.Packets {
+			got, ok := info.Code.Info(packetType, info.Version)
+			assert.True(t, ok, info.Name)
+			assert.Equal(t, info.Name, got.Name)
+		}
+	}
+}
+
+func TestReasonCodeTranslation(t *testing.T) {
+	connack := map[ReasonCode]byte{
+		ReasonSuccess:                    Accepted,
+		ReasonUnsupportedProtocolVersion: ErrRefusedBadProtocolVersion,
+		ReasonClientIdentifierNotValid:   ErrRefusedIDRejected,
+		ReasonServerUnavailable:          ErrRefusedServerUnavailable,
+		ReasonServerBusy:                 ErrRefusedServerUnavailable,
+		ReasonBadUsernameOrPassword:      ErrRefusedBadUsernameOrPassword,
+		ReasonNotAuthorized:              ErrRefusedNotAuthorised,
+		ReasonBanned:                     ErrRefusedNotAuthorised,
+		ReasonProtocolError:              ErrRefusedServerUnavailable,
+	}
+	for r, code := range connack {
+		assert.Equal(t, code, r.ReturnCode(Connack), r.String())
+	}
+	for code := byte(Accepted); code <= ErrRefusedNotAuthorised; code++ {
+		r := ReasonCodeOf(Connack, code)
+		assert.True(t, r.Valid(Connack, MQTT5))
+		assert.Equal(t, code, r.ReturnCode(Connack))
+	}
+	assert.Equal(t, ReasonProtocolError, ReasonCodeOf(Connack, ErrProtocolViolation))
+	assert.Equal(t, ReasonUnspecifiedError, ReasonCodeOf(Connack, ErrNetworkError))
+
+	assert.Equal(t, byte(2), ReasonGrantedQoS2.ReturnCode(Suback))
+	assert.Equal(t, byte(Failure), ReasonWildcardSubscriptionsNotSupported.ReturnCode(Suback))
+	assert.Equal(t, ReasonGrantedQoS1, ReasonCodeOf(Suback, 1))
+	assert.Equal(t, ReasonUnspecifiedError, ReasonCodeOf(Suback, Failure))
+}
+
+func TestReasonCodeFormat(t *testing.T) {
+	d := NewDisconnectPacket()
+	d.SetVersion(MQTT5)
+	d.ReasonCode = ReasonCode(0x03)
+	assert.EqualError(t, d.Verify(), "DISCONNECT: unknown reason code 0x03")
+	assert.Contains(t, d.String(), "reasoncode: 0x03")
+	d.Close()
+
+	pa := NewPubackPacket()
+	pa.SetVersion(MQTT5)
+	pa.MessageID = 1
+	pa.ReasonCode = ReasonBanned
+	assert.EqualError(t, pa.Verify(), "PUBACK: unknown reason code 0x8A")
+	pa.Close()
+}
+
+func TestReasonCodeErr(t *testing.T) {
+	assert.Nil(t, ReasonSuccess.Err())
+	assert.Nil(t, ReasonGrantedQoS2.Err())
+	assert.Nil(t, ReasonCodeOf(Connack, Accepted).Err())
+
+	err := ReasonCodeOf(Connack, ErrRefusedNotAuthorised).Err()
+	assert.EqualError(t, err, "Not authorized")
+	assert.True(t, errors.Is(err, ReasonNotAuthorized.Err()))
+	assert.False(t, errors.Is(err, ReasonBanned.Err()))
+	var rerr ReasonError
+	assert.True(t, errors.As(fmt.Errorf("connect: %w", err), &rerr))
+	assert.Equal(t, ReasonNotAuthorized, rerr.Code())
+
+	for code := byte(ErrRefusedBadProtocolVersion); code <= ErrRefusedNotAuthorised; code++ {
+		assert.Error(t, ReasonCodeOf(Connack, code).Err(), "%d", code)
+	}
+}
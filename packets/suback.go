@@ -16,9 +16,14 @@ var _subackPacketPool = sync.Pool{
 // Suback MQTT packet
 type SubackPacket struct {
 	*FixedHeader
-	MessageID   uint16
+	MessageID uint16
+	// ReturnCodes are the return codes, or the reason codes on protocol
+	// level 5, of the topic filters subscribed
 	ReturnCodes []byte
 	TraceID     string
+
+	// Properties are the properties of protocol level 5
+	Properties *Properties
 }
 
 // NewSubackPacket return the ping request packet
@@ -34,8 +39,26 @@ func (sa *SubackPacket) SetFixedHeader(fh *FixedHeader) {
 // SetTraceID will set traceid for tracing
 func (sa *SubackPacket) SetTraceID(id string) { sa.TraceID = id }
 
-// Verify packet availability
-func (sa *SubackPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (sa *SubackPacket) Verify() error {
+	if err := verifyMessageID(Suback, sa.MessageID); err != nil {
+		return err
+	}
+	if len(sa.ReturnCodes) == 0 {
+		return verifyError(Suback, "", "no return code")
+	}
+	for _, code := range sa.ReturnCodes {
+		if !ReasonCode(code).Valid(Suback, sa.version()) {
+			return verifyError(Suback, "MQTT-3.9.3-2", "invalid return code %d", code)
+		}
+	}
+	return verifyPacketProperties(sa.FixedHeader, sa.Properties)
+}
+
+func (sa *SubackPacket) verifyStrings(check UTF8Check) error {
+	return sa.Properties.verifyStrings(Suback, check)
+}
 
 // Type return the packet type
 func (sa *SubackPacket) Type() byte {
@@ -48,8 +71,10 @@ func (sa *SubackPacket) Reset() {
 	sa.FixedHeader.QoS = byte(0)
 	sa.FixedHeader.RemainingLength = 0
 	sa.FixedHeader.Retain = false
+	sa.FixedHeader.Version = 0
 	sa.MessageID = 0
 	sa.ReturnCodes = []byte{}
+	sa.Properties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
@@ -63,21 +88,34 @@ func (sa *SubackPacket) String() string {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (sa *SubackPacket) Write(w io.Writer) (n int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	if err = encodeUint16(sa.MessageID, b.b[5:]); err != nil {
+func (sa *SubackPacket) Write(w io.Writer) (int, error) {
+	n, err := sa.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (sa *SubackPacket) WriteTo(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header, 2 bytes for the MessageID
+	n := 7
+	sb := getBuf(n + packetPropertiesSize(sa.FixedHeader, sa.Properties) + len(sa.ReturnCodes))
+	defer putBuf(sb)
+	if err := encodeUint16(sa.MessageID, sb[5:]); err != nil {
 		return 0, err
 	}
-	sa.FixedHeader.RemainingLength = 2 + len(sa.ReturnCodes)
-
-	m := sa.FixedHeader.pack(b.b[:5])
-	if m, err = w.Write(b.b[5-m : 7]); err != nil {
-		return m, err
+	if sa.version() == MQTT5 {
+		m, err := sa.Properties.Pack(sb[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += m
 	}
+	copy(sb[n:], sa.ReturnCodes)
+	return writeFrame(w, sa.FixedHeader, sb)
+}
 
-	n, err = w.Write(sa.ReturnCodes)
-	return n + m, err
+// Size returns the length of the packet on wire, fixed header included
+func (sa *SubackPacket) Size() int {
+	return packetSize(2 + packetPropertiesSize(sa.FixedHeader, sa.Properties) + len(sa.ReturnCodes))
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -85,11 +123,19 @@ func (sa *SubackPacket) Write(w io.Writer) (n int, err error) {
 func (sa *SubackPacket) Unpack(b []byte) (err error) {
 	sa.MessageID, err = decodeUint16(b)
 	if err != nil {
-		return err
+		return malformed(sa.FixedHeader, len(b), "truncated packet identifier")
+	}
+	n := 2
+	if sa.version() == MQTT5 {
+		var m int
+		if sa.Properties, m, err = unpackProperties(sa.FixedHeader, b, n, Suback); err != nil {
+			return err
+		}
+		n += m
 	}
-	sa.ReturnCodes = make([]byte, len(b)-2)
-	copy(sa.ReturnCodes, b[2:])
-	return err
+	sa.ReturnCodes = make([]byte, len(b)-n)
+	copy(sa.ReturnCodes, b[n:])
+	return nil
 }
 
 // Details returns a Details struct containing the QoS and
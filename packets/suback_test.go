@@ -36,3 +36,21 @@ func TestSubackPacket(t *testing.T) {
 
 	packet.Close()
 }
+
+func TestSubackPacketV5(t *testing.T) {
+	cp := NewSubackPacket()
+	cp.SetVersion(MQTT5)
+	cp.MessageID = 1
+	cp.ReturnCodes = []byte{0x02, 0x9E}
+	assert.NoError(t, cp.Verify())
+	cp.ReturnCodes = []byte{0x11}
+	assert.Error(t, cp.Verify())
+	cp.Close()
+
+	// reason codes of protocol level 5 are not return codes
+	cp = NewSubackPacket()
+	cp.MessageID = 1
+	cp.ReturnCodes = []byte{0x9E}
+	assert.Error(t, cp.Verify())
+	cp.Close()
+}
@@ -3,7 +3,10 @@ package packets
 import (
 	"fmt"
 	"io"
+	"strings"
 	"sync"
+
+	"github.com/arthurkiller/mqtgo/topic"
 )
 
 var _subscribePacketPool = sync.Pool{
@@ -12,6 +15,33 @@ var _subscribePacketPool = sync.Pool{
 	},
 }
 
+// SubscriptionOptions are the options of protocol level 5 of a topic filter,
+// the requested QoS excepted
+type SubscriptionOptions struct {
+	// NoLocal stops the messages published by the client from being
+	// forwarded to it
+	NoLocal bool
+	// RetainAsPublished keeps the RETAIN flag of the forwarded messages
+	RetainAsPublished bool
+	// RetainHandling selects when the retained messages are sent: 0 on
+	// subscribe, 1 on a new subscription only, 2 never
+	RetainHandling byte
+}
+
+// pack returns the subscription options byte of the options and qos
+func (o SubscriptionOptions) pack(qos byte) byte {
+	return qos | boolToByte(o.NoLocal)<<2 | boolToByte(o.RetainAsPublished)<<3 | o.RetainHandling<<4
+}
+
+// unpackSubscriptionOptions decodes the subscription options byte b
+func unpackSubscriptionOptions(b byte) (SubscriptionOptions, byte) {
+	return SubscriptionOptions{
+		NoLocal:           b&0x04 != 0,
+		RetainAsPublished: b&0x08 != 0,
+		RetainHandling:    b >> 4 & 0x03,
+	}, b & 0x03
+}
+
 // SubscribePacket is an internal representation of the fields of the
 // Subscribe MQTT packet
 type SubscribePacket struct {
@@ -20,6 +50,12 @@ type SubscribePacket struct {
 	Topics    []string
 	QoSs      []byte
 	TraceID   string
+
+	// Options are the subscription options of protocol level 5 of each
+	// topic filter, nil stands for the default options of all of them
+	Options []SubscriptionOptions
+	// Properties are the properties of protocol level 5
+	Properties *Properties
 }
 
 // NewSubscribePacket return the ping request packet
@@ -35,8 +71,114 @@ func (s *SubscribePacket) SetFixedHeader(fh *FixedHeader) {
 // SetTraceID will set traceid for tracing
 func (s *SubscribePacket) SetTraceID(id string) { s.TraceID = id }
 
-// Verify packet availability
-func (s *SubscribePacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (s *SubscribePacket) Verify() error {
+	if err := verifyMessageID(Subscribe, s.MessageID); err != nil {
+		return err
+	}
+	if len(s.Topics) == 0 {
+		return verifyError(Subscribe, "MQTT-3.8.3-3", "no topic filter")
+	}
+	if len(s.Topics) != len(s.QoSs) {
+		return verifyError(Subscribe, "", "%d topic filters with %d QoSs", len(s.Topics), len(s.QoSs))
+	}
+	if s.Options != nil && len(s.Options) != len(s.Topics) {
+		return verifyError(Subscribe, "", "%d topic filters with %d subscription options", len(s.Topics), len(s.Options))
+	}
+	if err := verifyPacketProperties(s.FixedHeader, s.Properties); err != nil {
+		return err
+	}
+	for i, filter := range s.Topics {
+		if err := verifyTopicFilter(Subscribe, s.version(), &specTopics, filter); err != nil {
+			return err
+		}
+		if s.QoSs[i] > 2 {
+			return verifyError(Subscribe, "MQTT-3-8.3-4", "invalid QoS %d of topic filter %q", s.QoSs[i], filter)
+		}
+		if err := s.verifyOptions(i); err != nil {
+			return err
+		}
+	}
+	return s.verifyStrings(UTF8CheckSpec)
+}
+
+func (s *SubscribePacket) verifyTopics(v *topic.Validator) error {
+	for _, filter := range s.Topics {
+		if err := verifyTopicFilter(Subscribe, s.version(), v, filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyOptions checks the subscription options of the i-th topic filter
+func (s *SubscribePacket) verifyOptions(i int) error {
+	o := s.option(i)
+	if o == (SubscriptionOptions{}) {
+		return nil
+	}
+	if s.version() != MQTT5 {
+		return verifyError(Subscribe, "", "subscription options on protocol level %d", s.version())
+	}
+	if o.RetainHandling > 2 {
+		return verifyError(Subscribe, "", "invalid retain handling %d of topic filter %q", o.RetainHandling, s.Topics[i])
+	}
+	if o.NoLocal && strings.HasPrefix(s.Topics[i], topic.SharePrefix) {
+		return verifyError(Subscribe, "MQTT-3.8.3-4", "no local set on shared subscription %q", s.Topics[i])
+	}
+	return nil
+}
+
+// option returns the subscription options of the i-th topic filter
+func (s *SubscribePacket) option(i int) SubscriptionOptions {
+	if i < len(s.Options) {
+		return s.Options[i]
+	}
+	return SubscriptionOptions{}
+}
+
+func (s *SubscribePacket) verifyStrings(check UTF8Check) error {
+	for _, topic := range s.Topics {
+		if err := verifyString(Subscribe, "topic filter", topic, check); err != nil {
+			return err
+		}
+	}
+	return s.Properties.verifyStrings(Subscribe, check)
+}
+
+// Subscribe stores the subscriptions of the packet into t for subscriber
+// with the QoSs granted by sa, the SUBACK replying to it, or the ones
+// requested when sa is nil. The topic filters refused by sa are skipped, the
+// shared subscriptions are recognized on protocol level 5. replaced reports
+// the subscriptions replacing an existing one.
+func (s *SubscribePacket) Subscribe(t *topic.Trie, subscriber string, sa *SubackPacket) (replaced []bool, err error) {
+	if len(s.Topics) != len(s.QoSs) {
+		return nil, verifyError(Subscribe, "", "%d topic filters with %d QoSs", len(s.Topics), len(s.QoSs))
+	}
+	if sa != nil && len(sa.ReturnCodes) != len(s.Topics) {
+		return nil, verifyError(Suback, "MQTT-3.9.3-1", "%d return codes for %d topic filters", len(sa.ReturnCodes), len(s.Topics))
+	}
+	replaced = make([]bool, len(s.Topics))
+	for i, filter := range s.Topics {
+		sub := topic.Subscription{Subscriber: subscriber, QoS: s.QoSs[i]}
+		if sa != nil {
+			if sa.ReturnCodes[i] >= 0x80 {
+				continue
+			}
+			sub.QoS = sa.ReturnCodes[i]
+		}
+		if s.version() == MQTT5 {
+			if share, f, ok := topic.SplitShared(filter); ok {
+				sub.Share, filter = share, f
+			}
+		}
+		if replaced[i], err = t.Subscribe(filter, sub); err != nil {
+			return replaced, topicError(Subscribe, "topic filter", err)
+		}
+	}
+	return replaced, nil
+}
 
 // Type return the packet type
 func (s *SubscribePacket) Type() byte {
@@ -49,9 +191,12 @@ func (s *SubscribePacket) Reset() {
 	s.FixedHeader.QoS = byte(1)
 	s.FixedHeader.RemainingLength = 0
 	s.FixedHeader.Retain = false
+	s.FixedHeader.Version = 0
 	s.MessageID = 0
 	s.Topics = []string{}
 	s.QoSs = []byte{}
+	s.Options = nil
+	s.Properties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
@@ -66,32 +211,50 @@ func (s *SubscribePacket) String() string {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (s *SubscribePacket) Write(w io.Writer) (n int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	if err = encodeUint16(s.MessageID, b.b[5:]); err != nil {
+func (s *SubscribePacket) Write(w io.Writer) (int, error) {
+	n, err := s.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (s *SubscribePacket) WriteTo(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header, 2 bytes for the MessageID
+	n := 7 + packetPropertiesSize(s.FixedHeader, s.Properties)
+	for _, topic := range s.Topics {
+		n += len(topic) + 3
+	}
+	sb := getBuf(n)
+	defer putBuf(sb)
+	if err := encodeUint16(s.MessageID, sb[5:]); err != nil {
 		return 0, err
 	}
 
-	sb := make([]byte, n)
+	n = 7
+	if s.version() == MQTT5 {
+		m, err := s.Properties.Pack(sb[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
 	for i, topic := range s.Topics {
-		sb = append(sb, make([]byte, len(topic)+3)...)
-		if err = encodeString(topic, sb[n:]); err != nil {
+		if err := encodeString(topic, sb[n:]); err != nil {
 			return 0, err
 		}
 		n += len(topic) + 2
-		sb[n] = s.QoSs[i]
+		sb[n] = s.option(i).pack(s.QoSs[i])
 		n++
 	}
-	s.FixedHeader.RemainingLength = n + 2
+	return writeFrame(w, s.FixedHeader, sb)
+}
 
-	m := s.FixedHeader.pack(b.b[:5])
-	if m, err = w.Write(b.b[5-m : 7]); err != nil {
-		return m, err
+// Size returns the length of the packet on wire, fixed header included
+func (s *SubscribePacket) Size() int {
+	rl := 2 + packetPropertiesSize(s.FixedHeader, s.Properties)
+	for _, topic := range s.Topics {
+		rl += len(topic) + 3
 	}
-
-	n, err = w.Write(sb[:n])
-	return n + m, err
+	return packetSize(rl)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -104,31 +267,42 @@ func (s *SubscribePacket) Unpack(b []byte) error {
 	var topic string
 	s.MessageID, err = decodeUint16(b)
 	if err != nil {
-		return err
+		return malformed(s.FixedHeader, len(b), "truncated packet identifier")
 	}
 	n = 2
-	payloadLength := s.FixedHeader.RemainingLength - 2
-	for payloadLength > 0 {
-		// cut off buf n
-		if len(b) <= n {
-			return io.ErrShortBuffer
+	v5 := s.version() == MQTT5
+	if v5 {
+		s.Properties, m, err = unpackProperties(s.FixedHeader, b, n, Subscribe)
+		if err != nil {
+			return err
 		}
+		n += m
+	}
+	// the payload is bounded by the body itself, never by the remaining
+	// length of the header which may not match it
+	for n < len(b) {
 		topic, m, err = decodeString(b[n:])
 		if err != nil {
-			return err
+			return malformed(s.FixedHeader, n, "truncated topic filter")
 		}
 		n += m
 		s.Topics = append(s.Topics, topic)
 
 		// get qos 1 byte
 		if len(b) <= n {
-			return io.ErrShortBuffer
+			return malformed(s.FixedHeader, n, "missing requested QoS")
 		}
 		qos := b[n]
+		if v5 {
+			if qos&0xC0 != 0 {
+				return malformed(s.FixedHeader, n, "reserved subscription options bits set")
+			}
+			var o SubscriptionOptions
+			o, qos = unpackSubscriptionOptions(qos)
+			s.Options = append(s.Options, o)
+		}
 		n++
 		s.QoSs = append(s.QoSs, qos)
-
-		payloadLength -= m + 1 // m give the offset of decode topic string, plus 1 byte for QoS
 	}
 	return nil
 }
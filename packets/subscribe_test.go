@@ -2,8 +2,10 @@ package packets
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
+	"github.com/arthurkiller/mqtgo/topic"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,3 +43,96 @@ func TestSubscribePacket(t *testing.T) {
 
 	packet.Close()
 }
+
+func TestSubscribePacketV5(t *testing.T) {
+	cp := NewSubscribePacket()
+	cp.SetVersion(MQTT5)
+	cp.MessageID = 1
+	cp.Topics = []string{"a", "$share/g/b"}
+	cp.QoSs = []byte{1, 2}
+	cp.Options = []SubscriptionOptions{{NoLocal: true, RetainHandling: 1}, {RetainAsPublished: true}}
+	cp.Properties = &Properties{SubscriptionIdentifier: []int{7}}
+	assert.NoError(t, cp.Verify())
+
+	b, err := Marshal(cp)
+	assert.NoError(t, err)
+	assert.Equal(t, cp.Size(), len(b))
+	// options of the first topic filter: QoS 1, no local, retain handling 1
+	assert.Equal(t, byte(0x15), b[10])
+
+	d := NewDecoder(bytes.NewReader(b))
+	d.ProtocolVersion = MQTT5
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	s := packet.(*SubscribePacket)
+	assert.Equal(t, cp.QoSs, s.QoSs)
+	assert.Equal(t, cp.Options, s.Options)
+	assert.Equal(t, []int{7}, s.Properties.SubscriptionIdentifier)
+	packet.Close()
+
+	invalid := map[string]func(s *SubscribePacket){
+		"retain handling": func(s *SubscribePacket) { s.Options[1].RetainHandling = 3 },
+		"shared no local": func(s *SubscribePacket) { s.Options[1].NoLocal = true },
+		"options count":   func(s *SubscribePacket) { s.Options = s.Options[:1] },
+		"below level 5":   func(s *SubscribePacket) { s.Properties = nil; s.SetVersion(MQTT311) },
+	}
+	for name, modify := range invalid {
+		s := NewSubscribePacket()
+		s.SetVersion(MQTT5)
+		s.MessageID = 1
+		s.Topics = []string{"a", "$share/g/b"}
+		s.QoSs = []byte{1, 2}
+		s.Options = []SubscriptionOptions{{NoLocal: true}, {}}
+		modify(s)
+		assert.Error(t, s.Verify(), name)
+		s.Close()
+	}
+	cp.Close()
+
+	// the reserved bits of the subscription options
+	d = NewDecoder(bytes.NewReader([]byte{130, 7, 0, 1, 0, 0, 1, 97, 0x40}))
+	d.ProtocolVersion = MQTT5
+	_, err = d.Decode()
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+}
+
+func TestSubscribePacketSubscribe(t *testing.T) {
+	var tr topic.Trie
+	s := NewSubscribePacket()
+	s.MessageID = 1
+	s.Topics = []string{"a/+", "a/b", "$share/g/a/#"}
+	s.QoSs = []byte{2, 1, 0}
+	sa := NewSubackPacket()
+	sa.ReturnCodes = []byte{1, 0x80, 0}
+	replaced, err := s.Subscribe(&tr, "c1", sa)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false, false, false}, replaced)
+	assert.Equal(t, 2, tr.Len())
+	// a shared subscription on protocol level 3.1.1 is a plain filter
+	assert.Equal(t, []topic.Subscription{{Subscriber: "c1", QoS: 1}}, tr.Match("a/b"))
+
+	s.SetVersion(MQTT5)
+	replaced, err = s.Subscribe(&tr, "c2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false, false, false}, replaced)
+	assert.Equal(t, []topic.Subscription{
+		{Subscriber: "c1", QoS: 1},
+		{Subscriber: "c2", QoS: 2},
+		{Subscriber: "c2", Share: "g", QoS: 0, Filter: "a/#"},
+	}, tr.Match("a/b"))
+	replaced, err = s.Subscribe(&tr, "c2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true}, replaced)
+
+	short := NewSubackPacket()
+	short.ReturnCodes = []byte{1}
+	_, err = s.Subscribe(&tr, "c3", short)
+	assert.Error(t, err)
+	s.Topics = []string{"a/#/b"}
+	s.QoSs = []byte{0}
+	_, err = s.Subscribe(&tr, "c3", nil)
+	assert.EqualError(t, err, `SUBSCRIBE: multi-level wildcard not alone as the last level "a/#/b" [MQTT-4.7.1-2]`)
+	s.Close()
+	sa.Close()
+	short.Close()
+}
@@ -18,6 +18,12 @@ type UnsubackPacket struct {
 	*FixedHeader
 	MessageID uint16
 	TraceID   string
+
+	// ReasonCodes are the reason codes of protocol level 5 of the topic
+	// filters unsubscribed
+	ReasonCodes []ReasonCode
+	// Properties are the properties of protocol level 5
+	Properties *Properties
 }
 
 // NewUnsubackPacket return the ping request packet
@@ -28,8 +34,32 @@ func NewUnsubackPacket() *UnsubackPacket {
 // SetTraceID will set traceid for tracing
 func (ua *UnsubackPacket) SetTraceID(id string) { ua.TraceID = id }
 
-// Verify packet availability
-func (ua *UnsubackPacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (ua *UnsubackPacket) Verify() error {
+	if err := verifyMessageID(Unsuback, ua.MessageID); err != nil {
+		return err
+	}
+	if ua.version() != MQTT5 {
+		if len(ua.ReasonCodes) > 0 {
+			return verifyError(Unsuback, "", "reason codes on protocol level %d", ua.version())
+		}
+		return verifyPacketProperties(ua.FixedHeader, ua.Properties)
+	}
+	if len(ua.ReasonCodes) == 0 {
+		return verifyError(Unsuback, "", "no reason code")
+	}
+	for _, code := range ua.ReasonCodes {
+		if !code.Valid(Unsuback, MQTT5) {
+			return verifyError(Unsuback, "", "invalid reason code 0x%02X", byte(code))
+		}
+	}
+	return verifyPacketProperties(ua.FixedHeader, ua.Properties)
+}
+
+func (ua *UnsubackPacket) verifyStrings(check UTF8Check) error {
+	return ua.Properties.verifyStrings(Unsuback, check)
+}
 
 // Type return the packet type
 func (ua *UnsubackPacket) Type() byte {
@@ -42,7 +72,10 @@ func (ua *UnsubackPacket) Reset() {
 	ua.FixedHeader.QoS = byte(0)
 	ua.FixedHeader.RemainingLength = 0
 	ua.FixedHeader.Retain = false
+	ua.FixedHeader.Version = 0
 	ua.MessageID = 0
+	ua.ReasonCodes = nil
+	ua.Properties = nil
 }
 
 // SetFixedHeader will set fh for our header
@@ -62,25 +95,64 @@ func (ua *UnsubackPacket) String() string {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (ua *UnsubackPacket) Write(w io.Writer) (n int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	ua.FixedHeader.RemainingLength = 2
-	ua.FixedHeader.pack(b.b[:5])
-	if err = encodeUint16(ua.MessageID, b.b[5:]); err != nil {
+func (ua *UnsubackPacket) Write(w io.Writer) (int, error) {
+	n, err := ua.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (ua *UnsubackPacket) WriteTo(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header
+	b := getBuf(5 + ua.length())
+	defer putBuf(b)
+	if err := encodeUint16(ua.MessageID, b[5:]); err != nil {
 		return 0, err
 	}
-	return w.Write(b.b[3:7])
+	if ua.version() == MQTT5 {
+		n, err := ua.Properties.Pack(b[7:])
+		if err != nil {
+			return 0, err
+		}
+		for i, code := range ua.ReasonCodes {
+			b[7+n+i] = byte(code)
+		}
+	}
+	return writeFrame(w, ua.FixedHeader, b)
+}
+
+// Size returns the length of the packet on wire, fixed header included
+func (ua *UnsubackPacket) Size() int {
+	return packetSize(ua.length())
+}
+
+// length returns the remaining length of the packet, the reason codes and
+// properties are only carried on protocol level 5
+func (ua *UnsubackPacket) length() int {
+	if ua.version() != MQTT5 {
+		return 2
+	}
+	return 2 + ua.Properties.Size() + len(ua.ReasonCodes)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
 // header has been read
 func (ua *UnsubackPacket) Unpack(b []byte) (err error) {
 	if len(b) < 2 {
-		return io.ErrShortBuffer
+		return malformed(ua.FixedHeader, len(b), "truncated packet identifier")
 	}
 	ua.MessageID, err = decodeUint16(b)
-	return err
+	if err != nil || ua.version() != MQTT5 {
+		return err
+	}
+	var n int
+	if ua.Properties, n, err = unpackProperties(ua.FixedHeader, b, 2, Unsuback); err != nil {
+		return err
+	}
+	ua.ReasonCodes = make([]ReasonCode, len(b)-2-n)
+	for i := range ua.ReasonCodes {
+		ua.ReasonCodes[i] = ReasonCode(b[2+n+i])
+	}
+	return nil
 }
 
 // Details returns a Details struct containing the QoS and
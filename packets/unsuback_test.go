@@ -33,3 +33,34 @@ func TestUnsubackPacket(t *testing.T) {
 	assert.Equal(t, uint16(1234), cp.MessageID, "Ununsuback messageID not matched")
 	packet.Close()
 }
+
+func TestUnsubackPacketV5(t *testing.T) {
+	cp := NewUnsubackPacket()
+	cp.SetVersion(MQTT5)
+	cp.MessageID = 1
+	assert.Error(t, cp.Verify())
+	cp.ReasonCodes = []ReasonCode{0x00, 0x11}
+	cp.Properties = &Properties{ReasonString: "gone"}
+	assert.NoError(t, cp.Verify())
+
+	b, err := Marshal(cp)
+	assert.NoError(t, err)
+	assert.Equal(t, cp.Size(), len(b))
+	cp.Close()
+
+	d := NewDecoder(bytes.NewReader(b))
+	d.ProtocolVersion = MQTT5
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	ua := packet.(*UnsubackPacket)
+	assert.Equal(t, []ReasonCode{0x00, 0x11}, ua.ReasonCodes)
+	assert.Equal(t, "gone", ua.Properties.ReasonString)
+	packet.Close()
+
+	// reason codes are not carried below the protocol level 5
+	cp = NewUnsubackPacket()
+	cp.MessageID = 1
+	cp.ReasonCodes = []ReasonCode{0x00}
+	assert.Error(t, cp.Verify())
+	cp.Close()
+}
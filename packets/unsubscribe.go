@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"sync"
+
+	"github.com/arthurkiller/mqtgo/topic"
 )
 
 var _unsubscribePacketPool = sync.Pool{
@@ -19,6 +21,9 @@ type UnsubscribePacket struct {
 	MessageID uint16
 	Topics    []string
 	TraceID   string
+
+	// Properties are the properties of protocol level 5
+	Properties *Properties
 }
 
 // NewUnsubscribePacket return the ping request packet
@@ -34,8 +39,60 @@ func (u *UnsubscribePacket) SetFixedHeader(fh *FixedHeader) {
 // SetTraceID will set traceid for tracing
 func (u *UnsubscribePacket) SetTraceID(id string) { u.TraceID = id }
 
-// Verify packet availability
-func (u *UnsubscribePacket) Verify() bool { return true }
+// Verify checks the packet against the MQTT 3.1.1 specification, or the
+// MQTT 5 one on protocol level 5
+func (u *UnsubscribePacket) Verify() error {
+	if err := verifyMessageID(Unsubscribe, u.MessageID); err != nil {
+		return err
+	}
+	if len(u.Topics) == 0 {
+		return verifyError(Unsubscribe, "MQTT-3.10.3-2", "no topic filter")
+	}
+	if err := u.verifyTopics(&specTopics); err != nil {
+		return err
+	}
+	if err := verifyPacketProperties(u.FixedHeader, u.Properties); err != nil {
+		return err
+	}
+	return u.verifyStrings(UTF8CheckSpec)
+}
+
+func (u *UnsubscribePacket) verifyTopics(v *topic.Validator) error {
+	for _, filter := range u.Topics {
+		if err := verifyTopicFilter(Unsubscribe, u.version(), v, filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *UnsubscribePacket) verifyStrings(check UTF8Check) error {
+	for _, topic := range u.Topics {
+		if err := verifyString(Unsubscribe, "topic filter", topic, check); err != nil {
+			return err
+		}
+	}
+	return u.Properties.verifyStrings(Unsubscribe, check)
+}
+
+// Unsubscribe deletes the subscriptions of the packet from t for subscriber,
+// the shared subscriptions are recognized on protocol level 5. It returns
+// the reason codes of an UNSUBACK of protocol level 5 replying to it.
+func (u *UnsubscribePacket) Unsubscribe(t *topic.Trie, subscriber string) []ReasonCode {
+	codes := make([]ReasonCode, len(u.Topics))
+	for i, filter := range u.Topics {
+		sub := topic.Subscription{Subscriber: subscriber}
+		if u.version() == MQTT5 {
+			if share, f, ok := topic.SplitShared(filter); ok {
+				sub.Share, filter = share, f
+			}
+		}
+		if !t.Unsubscribe(filter, sub) {
+			codes[i] = ReasonNoSubscriptionExisted
+		}
+	}
+	return codes
+}
 
 // Type return the packet type
 func (u *UnsubscribePacket) Type() byte {
@@ -48,8 +105,10 @@ func (u *UnsubscribePacket) Reset() {
 	u.FixedHeader.QoS = byte(1)
 	u.FixedHeader.RemainingLength = 0
 	u.FixedHeader.Retain = false
+	u.FixedHeader.Version = 0
 	u.MessageID = 0
 	u.Topics = []string{}
+	u.Properties = nil
 }
 
 // Close reset the packet field put the control packet back to pool
@@ -64,30 +123,48 @@ func (u *UnsubscribePacket) String() string {
 }
 
 // Write will write the packets mostly into a net.Conn
-func (u *UnsubscribePacket) Write(w io.Writer) (n int, err error) {
-	b := Getbuf()
-	defer Putbuf(b)
-	if err = encodeUint16(u.MessageID, b.b[5:]); err != nil {
+func (u *UnsubscribePacket) Write(w io.Writer) (int, error) {
+	n, err := u.WriteTo(w)
+	return int(n), err
+}
+
+// WriteTo writes the packet into w with a single Write
+func (u *UnsubscribePacket) WriteTo(w io.Writer) (int64, error) {
+	// 5 bytes reserved for the fixed header, 2 bytes for the MessageID
+	n := 7 + packetPropertiesSize(u.FixedHeader, u.Properties)
+	for _, topic := range u.Topics {
+		n += len(topic) + 2
+	}
+	ub := getBuf(n)
+	defer putBuf(ub)
+	if err := encodeUint16(u.MessageID, ub[5:]); err != nil {
 		return 0, err
 	}
 
-	ub := make([]byte, n)
+	n = 7
+	if u.version() == MQTT5 {
+		m, err := u.Properties.Pack(ub[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
 	for _, topic := range u.Topics {
-		ub = append(ub, make([]byte, len(topic)+2)...)
-		if err = encodeString(topic, ub[n:]); err != nil {
+		if err := encodeString(topic, ub[n:]); err != nil {
 			return 0, err
 		}
 		n += len(topic) + 2
 	}
-	u.FixedHeader.RemainingLength = n + 2
+	return writeFrame(w, u.FixedHeader, ub)
+}
 
-	m := u.FixedHeader.pack(b.b[:5])
-	if m, err = w.Write(b.b[5-m : 7]); err != nil {
-		return m, err
+// Size returns the length of the packet on wire, fixed header included
+func (u *UnsubscribePacket) Size() int {
+	rl := 2 + packetPropertiesSize(u.FixedHeader, u.Properties)
+	for _, topic := range u.Topics {
+		rl += len(topic) + 2
 	}
-
-	n, err = w.Write(ub[:n])
-	return n + m, err
+	return packetSize(rl)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -99,20 +176,27 @@ func (u *UnsubscribePacket) Unpack(b []byte) error {
 	)
 	u.MessageID, err = decodeUint16(b)
 	if err != nil {
-		return err
+		return malformed(u.FixedHeader, len(b), "truncated packet identifier")
 	}
 	n = 2
+	if u.version() == MQTT5 {
+		u.Properties, m, err = unpackProperties(u.FixedHeader, b, n, Unsubscribe)
+		if err != nil {
+			return err
+		}
+		n += m
+	}
 
 	var topic string
-	payloadLength := u.FixedHeader.RemainingLength - 2
-	for payloadLength > 0 {
+	// the payload is bounded by the body itself, never by the remaining
+	// length of the header which may not match it
+	for n < len(b) {
 		topic, m, err = decodeString(b[n:])
 		if err != nil {
-			return err
+			return malformed(u.FixedHeader, n, "truncated topic filter")
 		}
 		n += m
 		u.Topics = append(u.Topics, topic)
-		payloadLength -= m
 	}
 	return nil
 }
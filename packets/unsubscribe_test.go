@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/arthurkiller/mqtgo/topic"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -39,3 +40,19 @@ func TestUnsubscribePacket(t *testing.T) {
 	assert.Equal(t, []string{"hello", "world", "/this/is/mqtt"}, cp.Topics, "Subscribe topics not matched")
 	packet.Close()
 }
+
+func TestUnsubscribePacketUnsubscribe(t *testing.T) {
+	var tr topic.Trie
+	tr.Subscribe("a/+", topic.Subscription{Subscriber: "c1", QoS: 1})
+	tr.Subscribe("a/b", topic.Subscription{Subscriber: "c1", Share: "g"})
+
+	u := NewUnsubscribePacket()
+	u.MessageID = 1
+	u.Topics = []string{"a/+", "$share/g/a/b", "a/#"}
+	u.SetVersion(MQTT5)
+	assert.Equal(t, []ReasonCode{ReasonSuccess, ReasonSuccess, ReasonNoSubscriptionExisted}, u.Unsubscribe(&tr, "c1"))
+	assert.Equal(t, 0, tr.Len())
+	assert.Equal(t, []ReasonCode{ReasonNoSubscriptionExisted, ReasonNoSubscriptionExisted, ReasonNoSubscriptionExisted},
+		u.Unsubscribe(&tr, "c1"))
+	u.Close()
+}
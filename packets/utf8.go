@@ -0,0 +1,56 @@
+package packets
+
+import (
+	"unicode/utf8"
+)
+
+// UTF8Check tells how the MQTT strings of a packet are validated
+type UTF8Check int
+
+// Below are the UTF8Check levels
+const (
+	// UTF8CheckNone skips the validation
+	UTF8CheckNone UTF8Check = iota
+	// UTF8CheckSpec rejects ill-formed UTF-8, surrogates included, and
+	// U+0000, as MQTT-1.5.3-1 and MQTT-1.5.3-2 require
+	UTF8CheckSpec
+	// UTF8CheckStrict also rejects the control characters and the
+	// non-characters, which the specification says should not be used
+	UTF8CheckStrict
+)
+
+// stringsVerifier is implemented by the packets carrying MQTT strings
+type stringsVerifier interface {
+	// verifyStrings checks every string of the packet at the given level
+	verifyStrings(check UTF8Check) error
+}
+
+// verifyStrings checks the strings of cp if it carries any
+func verifyStrings(cp ControlPacket, check UTF8Check) error {
+	if sv, ok := cp.(stringsVerifier); ok && check != UTF8CheckNone {
+		return sv.verifyStrings(check)
+	}
+	return nil
+}
+
+// verifyString checks s at the given level, field names s in the error
+func verifyString(packetType byte, field, s string, check UTF8Check) error {
+	if check == UTF8CheckNone {
+		return nil
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			return stringError(packetType, "MQTT-1.5.3-1", "ill-formed UTF-8 in %s at %d", field, i)
+		case r == 0:
+			return stringError(packetType, "MQTT-1.5.3-2", "U+0000 in %s at %d", field, i)
+		case check == UTF8CheckStrict && (r < 0x20 || (r >= 0x7F && r <= 0x9F)):
+			return stringError(packetType, "", "control character %U in %s at %d", r, field, i)
+		case check == UTF8CheckStrict && ((r >= 0xFDD0 && r <= 0xFDEF) || r&0xFFFE == 0xFFFE):
+			return stringError(packetType, "", "non-character %U in %s at %d", r, field, i)
+		}
+		i += size
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyString(t *testing.T) {
+	valid := []string{"", "a/b", "\U0000FEFF", "A\U0002A6D4", "�"}
+	for _, s := range valid {
+		assert.NoError(t, verifyString(Publish, "topic name", s, UTF8CheckStrict), "%q", s)
+	}
+
+	spec := []string{"a\x00b", "\xff", "\xed\xa0\x80", "a\xc3"}
+	for _, s := range spec {
+		err := verifyString(Publish, "topic name", s, UTF8CheckSpec)
+		assert.True(t, errors.Is(err, ErrBadUTF8), "%q", s)
+		assert.NoError(t, verifyString(Publish, "topic name", s, UTF8CheckNone))
+	}
+
+	strict := []string{"a\x01", "\u007f", "\u0085", "﷐", "\U0001FFFE"}
+	for _, s := range strict {
+		assert.NoError(t, verifyString(Publish, "topic name", s, UTF8CheckSpec), "%q", s)
+		assert.Error(t, verifyString(Publish, "topic name", s, UTF8CheckStrict), "%q", s)
+	}
+}
+
+func TestDecoderUTF8(t *testing.T) {
+	// PUBLISH to topic "a\x00"
+	data := []byte{48, 6, 0, 2, 97, 0, 104, 105}
+
+	_, err := NewDecoder(bytes.NewBuffer(data)).Decode()
+	assert.True(t, errors.Is(err, ErrBadUTF8))
+	assert.EqualError(t, err, "PUBLISH: U+0000 in topic name at 1 [MQTT-1.5.3-2]")
+
+	d := NewDecoder(bytes.NewBuffer(data))
+	d.UTF8 = UTF8CheckNone
+	packet, err := d.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "a\x00", packet.(*PublishPacket).TopicName)
+	packet.Close()
+
+	_, err = NewParser(0).Feed(data)
+	assert.True(t, errors.Is(err, ErrBadUTF8))
+	_, _, err = ReadPacket(bytes.NewBuffer(data))
+	assert.True(t, errors.Is(err, ErrBadUTF8))
+	_, _, err = Unmarshal(data)
+	assert.True(t, errors.Is(err, ErrBadUTF8))
+	_, _, err = UnmarshalNoCopy(data)
+	assert.True(t, errors.Is(err, ErrBadUTF8))
+	// SUBSCRIBE to "a/\xff"
+	_, _, err = Unmarshal([]byte{130, 8, 0, 1, 0, 3, 97, 47, 0xff, 1})
+	assert.True(t, errors.Is(err, ErrBadUTF8))
+
+	p := NewSubscribePacket()
+	p.MessageID = 1
+	p.Topics = []string{"a/\xff"}
+	p.QoSs = []byte{1}
+	assert.True(t, errors.Is(NewEncoder(&bytes.Buffer{}).Encode(p), ErrBadUTF8))
+	assert.True(t, errors.Is(p.Verify(), ErrBadUTF8))
+
+	// the other encoding entry points refuse the packet as well
+	b, err := Marshal(p)
+	assert.True(t, errors.Is(err, ErrBadUTF8))
+	assert.Nil(t, b)
+	var perr *ProtocolError
+	assert.True(t, errors.As(err, &perr))
+	assert.False(t, perr.Close)
+	dst := []byte{1}
+	assert.Equal(t, dst, AppendPacket(dst, p))
+	w := &bytes.Buffer{}
+	n, err := WritePacket(w, p)
+	assert.True(t, errors.Is(err, ErrBadUTF8))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0, w.Len())
+
+	p.Topics = []string{"a/b"}
+	_, err = Marshal(p)
+	assert.NoError(t, err)
+	assert.Equal(t, 1+p.Size(), len(AppendPacket(dst, p)))
+	_, err = WritePacket(w, p)
+	assert.NoError(t, err)
+	p.Close()
+}
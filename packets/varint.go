@@ -0,0 +1,88 @@
+package packets
+
+import (
+	"fmt"
+	"io"
+)
+
+// Below are the errors of the variable byte integers, both of them are
+// ErrMalformedPacket as well
+var (
+	// ErrVarintOverflow return on an integer longer than 4 bytes or out of
+	// [0, MaxRemainingLength]
+	ErrVarintOverflow = fmt.Errorf("variable byte integer longer than 4 bytes: %w", ErrMalformedPacket)
+	// ErrVarintNotMinimal return on an integer not encoded in the minimum
+	// number of bytes
+	ErrVarintNotMinimal = fmt.Errorf("variable byte integer not in minimum bytes: %w", ErrMalformedPacket)
+)
+
+// EncodeVarint appends the variable byte integer encoding of v to dst, as the
+// remaining length and the MQTT 5 property lengths are encoded.
+func EncodeVarint(dst []byte, v int) ([]byte, error) {
+	if v < 0 || v > MaxRemainingLength {
+		return dst, ErrVarintOverflow
+	}
+	var b [4]byte
+	n := putVarint(b[:], v)
+	return append(dst, b[:n]...), nil
+}
+
+// DecodeVarint decodes the variable byte integer at the head of b and returns
+// it with the number of bytes it takes. io.ErrShortBuffer is returned if b
+// ends before the integer does.
+func DecodeVarint(b []byte) (v, n int, err error) {
+	var done bool
+	for n = 0; n < len(b); n++ {
+		if v, done, err = varintStep(v, n, b[n]); err != nil || done {
+			return v, n + 1, err
+		}
+	}
+	return 0, n, io.ErrShortBuffer
+}
+
+// VarintSize returns the number of bytes EncodeVarint takes to encode v
+func VarintSize(v int) int {
+	switch {
+	case v < 0x80:
+		return 1
+	case v < 0x4000:
+		return 2
+	case v < 0x200000:
+		return 3
+	}
+	return 4
+}
+
+// putVarint writes v at the head of dst, which must hold VarintSize(v)
+// bytes, and returns the number of bytes written
+func putVarint(dst []byte, v int) (n int) {
+	for {
+		c := byte(v % 0x80) // 0x80 = 128 = 1000 0000
+		v /= 0x80
+		// if there are more data to encode, set the top bit of this byte
+		if v > 0 {
+			c |= 0x80
+		}
+		dst[n] = c
+		n++
+		if v == 0 {
+			return n
+		}
+	}
+}
+
+// varintStep adds c, the i-th byte of a variable byte integer, into v.
+// done reports c is the last byte of the integer.
+func varintStep(v, i int, c byte) (value int, done bool, err error) {
+	value = v + int(c&0x7f)<<(7*uint(i))
+	if c&0x80 != 0 {
+		if i == 3 {
+			return 0, false, ErrVarintOverflow
+		}
+		return value, false, nil
+	}
+	if i > 0 && c == 0 {
+		return 0, false, ErrVarintNotMinimal
+	}
+	return value, true, nil
+}
@@ -0,0 +1,82 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVarint(t *testing.T) {
+	values := map[int][]byte{
+		0:         {0x00},
+		127:       {0x7F},
+		128:       {0x80, 0x01},
+		16383:     {0xFF, 0x7F},
+		16384:     {0x80, 0x80, 0x01},
+		2097151:   {0xFF, 0xFF, 0x7F},
+		2097152:   {0x80, 0x80, 0x80, 0x01},
+		268435455: {0xFF, 0xFF, 0xFF, 0x7F},
+	}
+	for v, encoded := range values {
+		b, err := EncodeVarint([]byte{0xAA}, v)
+		assert.NoError(t, err)
+		assert.Equal(t, append([]byte{0xAA}, encoded...), b)
+		assert.Equal(t, len(encoded), VarintSize(v))
+
+		d, n, err := DecodeVarint(append(encoded, 0xAA))
+		assert.NoError(t, err)
+		assert.Equal(t, v, d)
+		assert.Equal(t, len(encoded), n)
+	}
+
+	_, err := EncodeVarint(nil, MaxRemainingLength+1)
+	assert.Equal(t, ErrVarintOverflow, err)
+	_, err = EncodeVarint(nil, -1)
+	assert.Equal(t, ErrVarintOverflow, err)
+
+	_, _, err = DecodeVarint([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x01})
+	assert.Equal(t, ErrVarintOverflow, err)
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+	_, _, err = DecodeVarint([]byte{0x80, 0x00})
+	assert.Equal(t, ErrVarintNotMinimal, err)
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+	_, _, err = DecodeVarint([]byte{0xFF, 0xFF})
+	assert.Equal(t, io.ErrShortBuffer, err)
+}
+
+func TestRemainingLengthErrors(t *testing.T) {
+	// the offsets of the byte the remaining length is found malformed at
+	invalid := map[string]struct {
+		data   []byte
+		offset int
+	}{
+		"overflow":    {[]byte{0xC0, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, 4},
+		"not minimal": {[]byte{0xC0, 0x80, 0x80, 0x00}, 3},
+	}
+	for name, tc := range invalid {
+		data := tc.data
+		var perr *ProtocolError
+
+		_, _, err := ReadPacket(bytes.NewBuffer(data))
+		assert.True(t, errors.Is(err, ErrMalformedPacket), name)
+		assert.True(t, errors.As(err, &perr), name)
+		assert.Equal(t, tc.offset, perr.Offset, name)
+
+		_, err = NewDecoder(bytes.NewBuffer(data)).Decode()
+		assert.True(t, errors.Is(err, ErrMalformedPacket), name)
+
+		_, _, err = Unmarshal(data)
+		assert.True(t, errors.As(err, &perr), name)
+		assert.Equal(t, tc.offset, perr.Offset, name)
+
+		_, err = NewParser(0).Feed(data)
+		assert.True(t, errors.As(err, &perr), name)
+		assert.Equal(t, tc.offset, perr.Offset, name)
+	}
+
+	_, _, err := ReadPacket(bytes.NewBuffer([]byte{0xC0, 0x80}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -0,0 +1,83 @@
+package packets
+
+import (
+	"errors"
+
+	"github.com/arthurkiller/mqtgo/topic"
+)
+
+// verifyMessageID checks the packet identifier is non-zero
+func verifyMessageID(packetType byte, id uint16) error {
+	if id == 0 {
+		return verifyError(packetType, "MQTT-2.3.1-1", "packet identifier is zero")
+	}
+	return nil
+}
+
+// specTopics checks the topic rules of the specification only
+var specTopics topic.Validator
+
+// topicsVerifier is implemented by the packets carrying topic names or topic
+// filters
+type topicsVerifier interface {
+	// verifyTopics checks every topic of the packet with v
+	verifyTopics(v *topic.Validator) error
+}
+
+// verifyTopics checks the topics of cp with v if it carries any, nil v
+// checks nothing
+func verifyTopics(cp ControlPacket, v *topic.Validator) error {
+	if tv, ok := cp.(topicsVerifier); ok && v != nil {
+		return tv.verifyTopics(v)
+	}
+	return nil
+}
+
+// verifyTopicName checks the topic name of a packet of packetType with v
+func verifyTopicName(packetType byte, v *topic.Validator, name string) error {
+	if err := v.ValidateTopicName(name); err != nil {
+		return topicError(packetType, "topic name", err)
+	}
+	return nil
+}
+
+// verifyTopicFilter checks the topic filter of a packet of packetType with v,
+// the shared subscriptions are recognized on protocol level 5
+func verifyTopicFilter(packetType, version byte, v *topic.Validator, filter string) error {
+	if version == MQTT5 && !v.Shared {
+		shared := *v
+		shared.Shared = true
+		v = &shared
+	}
+	if err := v.ValidateTopicFilter(filter); err != nil {
+		return topicError(packetType, "topic filter", err)
+	}
+	return nil
+}
+
+// topicError returns the ProtocolError of a topic failed on validation with
+// err, field names the topic. The topics only breaking the limits of a
+// Validator, which the wire format can not exceed or the specification
+// allows, leave the connection open.
+func topicError(packetType byte, field string, err error) error {
+	var statement string
+	switch {
+	case errors.Is(err, topic.ErrTooManyLevels), errors.Is(err, topic.ErrDollar):
+		return refused(verifyError(packetType, "", "%v", err))
+	case errors.Is(err, topic.ErrTooLong):
+		return refused(verifyError(packetType, "MQTT-4.7.3-3", "%v", err))
+	case errors.Is(err, topic.ErrEmpty):
+		return verifyError(packetType, "MQTT-4.7.3-1", "empty %s", field)
+	case errors.Is(err, topic.ErrNull):
+		statement = "MQTT-4.7.3-2"
+	case errors.Is(err, topic.ErrWildcard) && packetType == Publish:
+		statement = "MQTT-3.3.2-2"
+	case errors.Is(err, topic.ErrWildcard):
+		statement = "MQTT-4.7.1-1"
+	case errors.Is(err, topic.ErrMultiLevel):
+		statement = "MQTT-4.7.1-2"
+	case errors.Is(err, topic.ErrSingleLevel):
+		statement = "MQTT-4.7.1-3"
+	}
+	return verifyError(packetType, statement, "%v", err)
+}
@@ -0,0 +1,174 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/arthurkiller/mqtgo/topic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	connect := func(f func(c *ConnectPacket)) ControlPacket {
+		c := NewConnectPacket()
+		c.ProtocolName = "MQTT"
+		c.ProtocolVersion = 4
+		c.CleanSession = true
+		c.ClientIdentifier = "test"
+		f(c)
+		return c
+	}
+	publish := func(qos byte, id uint16, topic string) ControlPacket {
+		p := NewPublishPacket()
+		p.QoS = qos
+		p.MessageID = id
+		p.TopicName = topic
+		return p
+	}
+	subscribe := func(topics []string, qoss []byte) ControlPacket {
+		s := NewSubscribePacket()
+		s.MessageID = 1
+		s.Topics = topics
+		s.QoSs = qoss
+		return s
+	}
+	suback := func(codes ...byte) ControlPacket {
+		sa := NewSubackPacket()
+		sa.MessageID = 1
+		sa.ReturnCodes = codes
+		return sa
+	}
+	unsubscribe := func(topics ...string) ControlPacket {
+		u := NewUnsubscribePacket()
+		u.MessageID = 1
+		u.Topics = topics
+		return u
+	}
+
+	valid := []ControlPacket{
+		connect(func(*ConnectPacket) {}),
+		connect(func(c *ConnectPacket) { c.ProtocolName, c.ProtocolVersion = "MQIsdp", 3 }),
+		publish(0, 0, "a/b"),
+		publish(2, 1, "a/b"),
+		subscribe([]string{"a/+", "#"}, []byte{0, 2}),
+		subscribe([]string{"+", "+/+/#", "$share/a/b"}, []byte{0, 1, 2}),
+		suback(0, 1, 2, 128),
+		unsubscribe("a/b"),
+		NewPingreqPacket(),
+		NewDisconnectPacket(),
+	}
+	for _, cp := range valid {
+		assert.NoError(t, cp.Verify(), "%v", cp)
+		cp.Close()
+	}
+
+	invalid := []ControlPacket{
+		connect(func(c *ConnectPacket) { c.ProtocolName = "MQTX" }),
+		connect(func(c *ConnectPacket) { c.ProtocolVersion = 3 }),
+		connect(func(c *ConnectPacket) { c.ReservedBit = 1 }),
+		connect(func(c *ConnectPacket) { c.WillFlag, c.WillQoS = true, 3 }),
+		connect(func(c *ConnectPacket) { c.WillRetain = true }),
+		connect(func(c *ConnectPacket) { c.PasswordFlag = true }),
+		connect(func(c *ConnectPacket) { c.ClientIdentifier, c.CleanSession = "", false }),
+		publish(3, 1, "a/b"),
+		publish(1, 0, "a/b"),
+		publish(0, 0, ""),
+		publish(0, 0, "a/+/b"),
+		publish(0, 0, "a/#"),
+		subscribe(nil, nil),
+		subscribe([]string{"a"}, []byte{3}),
+		subscribe([]string{"a", "b"}, []byte{1}),
+		subscribe([]string{""}, []byte{1}),
+		subscribe([]string{"a/#/b"}, []byte{1}),
+		subscribe([]string{"sport+"}, []byte{1}),
+		subscribe([]string{"a/b#"}, []byte{1}),
+		suback(),
+		suback(0, 3),
+		unsubscribe(),
+		unsubscribe("a/+b"),
+		connect(func(c *ConnectPacket) { c.WillFlag, c.WillTopic = true, "a/+" }),
+		NewPubackPacket(),
+		NewPubrecPacket(),
+		NewPubrelPacket(),
+		NewPubcompPacket(),
+		NewUnsubackPacket(),
+	}
+	for _, cp := range invalid {
+		assert.Error(t, cp.Verify(), "%v", cp)
+		cp.Close()
+	}
+
+	p := publish(0, 0, "a")
+	p.(*PublishPacket).Dup = true
+	assert.Error(t, p.Verify())
+	p.Close()
+
+	ca := NewConnackPacket()
+	ca.ReturnCode = ErrRefusedNotAuthorised
+	assert.NoError(t, ca.Verify())
+	ca.SessionPresent = true
+	assert.Error(t, ca.Verify())
+	ca.ReturnCode = 6
+	assert.Error(t, ca.Verify())
+	ca.Close()
+}
+
+func TestDecoderStrict(t *testing.T) {
+	// SUBSCRIBE with QoS 3
+	d := NewDecoder(bytes.NewBuffer([]byte{130, 6, 0, 1, 0, 1, 97, 3}))
+	d.Strict = true
+	_, err := d.Decode()
+	assert.EqualError(t, err, "SUBSCRIBE: invalid QoS 3 of topic filter \"a\" [MQTT-3-8.3-4]")
+	assert.True(t, errors.Is(err, ErrSpecViolation))
+}
+
+func TestVerifyTopics(t *testing.T) {
+	var perr *ProtocolError
+	s := NewSubscribePacket()
+	s.MessageID = 1
+	s.Topics, s.QoSs = []string{"a/#/b"}, []byte{0}
+	err := s.Verify()
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, "MQTT-4.7.1-2", perr.Statement)
+	assert.True(t, errors.Is(err, ErrSpecViolation))
+
+	// a shared subscription needs a filter on protocol level 5 only
+	s.Topics = []string{"$share/g"}
+	assert.NoError(t, s.Verify())
+	s.SetVersion(MQTT5)
+	assert.Error(t, s.Verify())
+	s.Close()
+
+	p := NewPublishPacket()
+	p.TopicName = string(make([]byte, 65536))
+	assert.True(t, errors.As(p.Verify(), &perr))
+	assert.Equal(t, "MQTT-4.7.3-3", perr.Statement)
+	p.TopicName = "a\x00b"
+	assert.True(t, errors.As(p.Verify(), &perr))
+	assert.Equal(t, "MQTT-4.7.3-2", perr.Statement)
+	p.Close()
+}
+
+func TestDecoderTopics(t *testing.T) {
+	// PUBLISH to a/b/c, SUBSCRIBE to a/+/#
+	in := []byte{48, 7, 0, 5, 97, 47, 98, 47, 99, 130, 10, 0, 1, 0, 5, 97, 47, 43, 47, 35, 1}
+	d := NewDecoder(bytes.NewReader(in))
+	d.Topics = &topic.Validator{MaxLevels: 2}
+	_, err := d.Decode()
+	assert.EqualError(t, err, "PUBLISH: too many topic levels: 3 levels, 2 at most")
+	_, err = d.Decode()
+	assert.EqualError(t, err, "SUBSCRIBE: too many topic levels: 3 levels, 2 at most")
+
+	ps := NewParser(0)
+	ps.Topics = &topic.Validator{NoDollar: true}
+	// PUBLISH to $a
+	_, err = ps.Feed([]byte{48, 4, 0, 2, 36, 97})
+	assert.EqualError(t, err, "PUBLISH: topic starting with $ \"$a\"")
+
+	// the shared subscriptions are topic filters only
+	d = NewDecoder(bytes.NewReader([]byte{48, 12, 0, 10, 36, 115, 104, 97, 114, 101, 47, 120, 47, 121}))
+	d.Topics = &topic.Validator{NoDollar: true, Shared: true}
+	_, err = d.Decode()
+	assert.EqualError(t, err, "PUBLISH: topic starting with $ \"$share/x/y\"")
+}
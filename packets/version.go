@@ -0,0 +1,94 @@
+package packets
+
+import "sync"
+
+// Below are the protocol levels carried by CONNECT. The level a connection
+// negotiated selects the wire format of all its following packets.
+const (
+	MQTT31  byte = 3
+	MQTT311 byte = 4
+	MQTT5   byte = 5
+)
+
+// ProtocolNames maps the protocol levels to the protocol name a CONNECT
+// of that level carries
+var ProtocolNames = map[byte]string{
+	MQTT31:  "MQIsdp",
+	MQTT311: "MQTT",
+	MQTT5:   "MQTT",
+}
+
+// supportedVersion reports whether the protocol name and level of a CONNECT
+// are known
+func supportedVersion(name string, v byte) bool {
+	n, ok := ProtocolNames[v]
+	return ok && n == name
+}
+
+// SetVersion sets the protocol level of the connection the packet belongs
+// to, which selects the wire format it is encoded and decoded with
+func (fh *FixedHeader) SetVersion(v byte) {
+	fh.Version = v
+}
+
+// version returns the protocol level of the packet, MQTT311 when not set
+func (fh *FixedHeader) version() byte {
+	if fh.Version == 0 {
+		return MQTT311
+	}
+	return fh.Version
+}
+
+// ConnState is the protocol state of one connection: its protocol level, set
+// by the first CONNECT decoded or encoded and locked from then on. A ConnState
+// shared by the Decoder and the Encoder of a connection makes the replies of a
+// server, and the packets decoded by a client, follow the level of the
+// CONNECT. It is safe for concurrent use.
+type ConnState struct {
+	mu        sync.Mutex
+	version   byte
+	connected bool
+}
+
+// NewConnState returns the state of a connection starting at protocol level
+// v, before any CONNECT
+func NewConnState(v byte) *ConnState {
+	return &ConnState{version: v}
+}
+
+// Version returns the protocol level of the connection
+func (s *ConnState) Version() byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+// Connected reports whether a CONNECT went through the connection
+func (s *ConnState) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+// Reset makes the state start over at protocol level v, for a new connection
+func (s *ConnState) Reset(v byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version, s.connected = v, false
+}
+
+// connect records the CONNECT c and returns the protocol level of the
+// connection, which is the one of c when supported. A second CONNECT is a
+// protocol violation.
+func (s *ConnState) connect(c *ConnectPacket) (byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connected {
+		return s.version, verifyError(Connect, "MQTT-3.1.0-2", "second CONNECT on the connection")
+	}
+	s.connected = true
+	if supportedVersion(c.ProtocolName, c.ProtocolVersion) {
+		s.version = c.ProtocolVersion
+	}
+	return s.version, nil
+}
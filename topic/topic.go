@@ -0,0 +1,159 @@
+// Package topic validates MQTT topic names and topic filters and matches
+// them, see section 4.7 of the specification.
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxLength is the longest topic name or topic filter in bytes, the length of
+// an MQTT string
+const MaxLength = 65535
+
+// SharePrefix starts the topic filters of the shared subscriptions of the
+// protocol level 5: $share/{ShareName}/{filter}
+const SharePrefix = "$share/"
+
+// Below are the errors returned by the validation, wrapped with the topic
+var (
+	ErrEmpty         = errors.New("empty topic")
+	ErrTooLong       = errors.New("topic too long")
+	ErrTooManyLevels = errors.New("too many topic levels")
+	ErrNull          = errors.New("null character in topic")
+	ErrWildcard      = errors.New("wildcard in topic name")
+	ErrMultiLevel    = errors.New("multi-level wildcard not alone as the last level")
+	ErrSingleLevel   = errors.New("single-level wildcard not alone in its level")
+	ErrDollar        = errors.New("topic starting with $")
+	ErrShareName     = errors.New("invalid shared subscription")
+)
+
+// Validator validates topic names and topic filters. The zero value checks
+// the rules of the specification only.
+type Validator struct {
+	// MaxLength limits the length in bytes, zero means MaxLength
+	MaxLength int
+	// MaxLevels limits the number of levels, zero means no limit
+	MaxLevels int
+	// NoDollar rejects the topics starting with $, such as $SYS, the server
+	// keeps them for its own use
+	NoDollar bool
+	// Shared makes the topic filters starting with SharePrefix shared
+	// subscriptions, the share name and the filter following it are checked
+	// apart. It applies on protocol level 5 only.
+	Shared bool
+}
+
+// defaultValidator checks the rules of the specification
+var defaultValidator Validator
+
+// ValidateTopicName checks name with the rules of the specification
+func ValidateTopicName(name string) error {
+	return defaultValidator.ValidateTopicName(name)
+}
+
+// ValidateTopicFilter checks filter with the rules of the specification
+func ValidateTopicFilter(filter string) error {
+	return defaultValidator.ValidateTopicFilter(filter)
+}
+
+// ValidateTopicName checks the topic name of a PUBLISH or of a will message:
+// it is not empty and carries no wildcard
+func (v *Validator) ValidateTopicName(name string) error {
+	if err := v.validate(name, false); err != nil {
+		return err
+	}
+	if strings.ContainsAny(name, "+#") {
+		return fmt.Errorf("%w %q", ErrWildcard, name)
+	}
+	return v.validateLevels(name)
+}
+
+// ValidateTopicFilter checks the topic filter of a SUBSCRIBE or of an
+// UNSUBSCRIBE: it is not empty, a single-level wildcard fills a whole level
+// and a multi-level wildcard fills the last one
+func (v *Validator) ValidateTopicFilter(filter string) error {
+	if err := v.validate(filter, true); err != nil {
+		return err
+	}
+	f := filter
+	if v.Shared && strings.HasPrefix(f, SharePrefix) {
+		share, shared, ok := SplitShared(filter)
+		if !ok || share == "" || strings.ContainsAny(share, "+#") || shared == "" {
+			return fmt.Errorf("%w %q", ErrShareName, filter)
+		}
+		f = shared
+		if v.NoDollar && f[0] == '$' {
+			return fmt.Errorf("%w %q", ErrDollar, filter)
+		}
+	}
+
+	for rest := f; ; {
+		i := strings.IndexByte(rest, '/')
+		level := rest
+		if i >= 0 {
+			level = rest[:i]
+		}
+		switch {
+		case level == "#" && i >= 0, level != "#" && strings.IndexByte(level, '#') >= 0:
+			return fmt.Errorf("%w %q", ErrMultiLevel, filter)
+		case level != "+" && strings.IndexByte(level, '+') >= 0:
+			return fmt.Errorf("%w %q", ErrSingleLevel, filter)
+		}
+		if i < 0 {
+			break
+		}
+		rest = rest[i+1:]
+	}
+	return v.validateLevels(f)
+}
+
+// validate checks the rules shared by topic names and topic filters, filter
+// tells s is a topic filter, a shared subscription is not refused on NoDollar
+func (v *Validator) validate(s string, filter bool) error {
+	max := v.MaxLength
+	if max <= 0 || max > MaxLength {
+		max = MaxLength
+	}
+	switch {
+	case len(s) == 0:
+		return ErrEmpty
+	case len(s) > max:
+		return fmt.Errorf("%w: %d bytes, %d at most", ErrTooLong, len(s), max)
+	case strings.IndexByte(s, 0) >= 0:
+		return fmt.Errorf("%w %q", ErrNull, s)
+	case v.NoDollar && s[0] == '$' && !(filter && v.Shared && strings.HasPrefix(s, SharePrefix)):
+		return fmt.Errorf("%w %q", ErrDollar, s)
+	}
+	return nil
+}
+
+// validateLevels checks the number of levels of s
+func (v *Validator) validateLevels(s string) error {
+	if v.MaxLevels <= 0 {
+		return nil
+	}
+	if n := Levels(s); n > v.MaxLevels {
+		return fmt.Errorf("%w: %d levels, %d at most", ErrTooManyLevels, n, v.MaxLevels)
+	}
+	return nil
+}
+
+// SplitShared splits the shared subscription $share/{ShareName}/{filter} into
+// its share name and filter, ok reports whether filter is one
+func SplitShared(filter string) (share, f string, ok bool) {
+	if !strings.HasPrefix(filter, SharePrefix) {
+		return "", filter, false
+	}
+	if share, f, ok = strings.Cut(filter[len(SharePrefix):], "/"); !ok {
+		return "", filter, false
+	}
+	return share, f, true
+}
+
+// Levels returns the number of levels of a topic name or topic filter, the
+// levels are separated by /
+func Levels(s string) int {
+	return strings.Count(s, "/") + 1
+}
@@ -0,0 +1,81 @@
+package topic
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTopicName(t *testing.T) {
+	for _, name := range []string{"a", "/", "a/b/c", "/a/", "$SYS/broker", " ", "a//b"} {
+		assert.NoError(t, ValidateTopicName(name), name)
+	}
+	for name, want := range map[string]error{
+		"":                         ErrEmpty,
+		"a/+":                      ErrWildcard,
+		"a/#":                      ErrWildcard,
+		"sport+":                   ErrWildcard,
+		"a\x00b":                   ErrNull,
+		strings.Repeat("a", 65536): ErrTooLong,
+	} {
+		assert.True(t, errors.Is(ValidateTopicName(name), want), "%.10q", name)
+	}
+}
+
+func TestValidateTopicFilter(t *testing.T) {
+	for _, filter := range []string{"#", "+", "a/#", "a/+/b", "+/+", "/+", "+/", "$SYS/#", "$share/g/a/+", "$share/g"} {
+		assert.NoError(t, ValidateTopicFilter(filter), filter)
+	}
+	for filter, want := range map[string]error{
+		"":              ErrEmpty,
+		"a/#/b":         ErrMultiLevel,
+		"#/":            ErrMultiLevel,
+		"a#":            ErrMultiLevel,
+		"sport/#tennis": ErrMultiLevel,
+		"sport+":        ErrSingleLevel,
+		"a/+b/c":        ErrSingleLevel,
+		"a\x00":         ErrNull,
+	} {
+		assert.True(t, errors.Is(ValidateTopicFilter(filter), want), filter)
+	}
+}
+
+func TestValidator(t *testing.T) {
+	v := &Validator{MaxLength: 16, MaxLevels: 3, NoDollar: true, Shared: true}
+	assert.NoError(t, v.ValidateTopicName("a/b/c"))
+	assert.NoError(t, v.ValidateTopicFilter("$share/g/a/+/#"))
+	assert.True(t, errors.Is(v.ValidateTopicName("a/b/c/d"), ErrTooManyLevels))
+	assert.True(t, errors.Is(v.ValidateTopicFilter("+/+/+/+"), ErrTooManyLevels))
+	assert.True(t, errors.Is(v.ValidateTopicName("abcdefghijklmnopq"), ErrTooLong))
+	assert.True(t, errors.Is(v.ValidateTopicName("$SYS"), ErrDollar))
+	assert.True(t, errors.Is(v.ValidateTopicFilter("$SYS/#"), ErrDollar))
+	assert.True(t, errors.Is(v.ValidateTopicFilter("$share/g/$a"), ErrDollar))
+	assert.True(t, errors.Is(v.ValidateTopicName("$share/g/a"), ErrDollar))
+	assert.EqualError(t, v.ValidateTopicFilter("a/#/b"), `multi-level wildcard not alone as the last level "a/#/b"`)
+
+	for _, filter := range []string{"$share/g", "$share/g/", "$share//a", "$share/+/a", "$share/g#/a"} {
+		assert.True(t, errors.Is(v.ValidateTopicFilter(filter), ErrShareName), filter)
+	}
+	assert.True(t, errors.Is(v.ValidateTopicFilter("$share/g/a/#/b"), ErrMultiLevel))
+}
+
+func TestLevels(t *testing.T) {
+	assert.Equal(t, 1, Levels("a"))
+	assert.Equal(t, 2, Levels("/"))
+	assert.Equal(t, 3, Levels("a/b/c"))
+}
+
+func TestSplitShared(t *testing.T) {
+	share, f, ok := SplitShared("$share/g/a/+")
+	assert.True(t, ok)
+	assert.Equal(t, "g", share)
+	assert.Equal(t, "a/+", f)
+	for _, filter := range []string{"a/b", "$share/g", "$SYS/share/g/a"} {
+		share, f, ok = SplitShared(filter)
+		assert.False(t, ok)
+		assert.Equal(t, "", share)
+		assert.Equal(t, filter, f)
+	}
+}
@@ -0,0 +1,239 @@
+package topic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Subscription is a subscription of a subscriber to a topic filter
+type Subscription struct {
+	// Subscriber identifies the subscriber, such as its client identifier
+	Subscriber string
+	// Share is the share name of a shared subscription, empty otherwise
+	Share string
+	// QoS is the maximum QoS granted to the subscription
+	QoS byte
+	// Filter is the topic filter of a shared subscription returned by Match,
+	// following the share name, it makes the share group with Share. It is
+	// empty for the other subscriptions.
+	Filter string
+}
+
+// key identifies the subscriptions of a node
+type key struct {
+	subscriber string
+	share      string
+}
+
+// node is a level of the trie, the wildcards levels are kept apart from the
+// other ones
+type node struct {
+	children map[string]*node
+	plus     *node
+	hash     *node
+	subs     map[key]byte
+	// filter is the topic filter ending at the node once subscribed to
+	filter string
+}
+
+// empty reports whether n holds no subscription and no level below it
+func (n *node) empty() bool {
+	return len(n.subs) == 0 && len(n.children) == 0 && n.plus == nil && n.hash == nil
+}
+
+// child returns the node of level below n, creating it if create is set
+func (n *node) child(level string, create bool) *node {
+	var c *node
+	switch level {
+	case "+":
+		if n.plus == nil && create {
+			n.plus = &node{}
+		}
+		c = n.plus
+	case "#":
+		if n.hash == nil && create {
+			n.hash = &node{}
+		}
+		c = n.hash
+	default:
+		if c = n.children[level]; c == nil && create {
+			if n.children == nil {
+				n.children = make(map[string]*node)
+			}
+			c = &node{}
+			n.children[level] = c
+		}
+	}
+	return c
+}
+
+// remove drops the node of level below n
+func (n *node) remove(level string) {
+	switch level {
+	case "+":
+		n.plus = nil
+	case "#":
+		n.hash = nil
+	default:
+		delete(n.children, level)
+	}
+}
+
+// Trie stores subscriptions by topic filter and matches them against topic
+// names in a time bound by the number of levels of the name, whatever the
+// number of filters. Its zero value is an empty trie, it is safe for
+// concurrent use.
+type Trie struct {
+	mu   sync.RWMutex
+	root node
+	n    int
+}
+
+// Subscribe stores the subscription s to filter, a subscription of the same
+// subscriber and share name to filter is replaced and reported by replaced.
+// A shared subscription takes the filter following the share name, see
+// SplitShared. The Filter of s is ignored.
+func (t *Trie) Subscribe(filter string, s Subscription) (replaced bool, err error) {
+	if err := ValidateTopicFilter(filter); err != nil {
+		return false, err
+	}
+	if s.Share != "" && strings.ContainsAny(s.Share, "/+#") {
+		return false, fmt.Errorf("%w: share name %q", ErrShareName, s.Share)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := &t.root
+	for _, level := range strings.Split(filter, "/") {
+		n = n.child(level, true)
+	}
+	if n.subs == nil {
+		n.subs = make(map[key]byte)
+		n.filter = filter
+	}
+	k := key{s.Subscriber, s.Share}
+	_, replaced = n.subs[k]
+	n.subs[k] = s.QoS
+	if !replaced {
+		t.n++
+	}
+	return replaced, nil
+}
+
+// Unsubscribe deletes the subscription of the subscriber and share name of s
+// to filter, the QoS of s is ignored. It reports whether the subscription
+// existed.
+func (t *Trie) Unsubscribe(filter string, s Subscription) bool {
+	levels := strings.Split(filter, "/")
+	path := make([]*node, 1, len(levels)+1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	path[0] = &t.root
+	for _, level := range levels {
+		c := path[len(path)-1].child(level, false)
+		if c == nil {
+			return false
+		}
+		path = append(path, c)
+	}
+	n := path[len(path)-1]
+	k := key{s.Subscriber, s.Share}
+	if _, ok := n.subs[k]; !ok {
+		return false
+	}
+	delete(n.subs, k)
+	t.n--
+
+	// drop the levels left empty
+	for i := len(levels); i > 0 && path[i].empty(); i-- {
+		path[i-1].remove(levels[i-1])
+	}
+	return true
+}
+
+// Len returns the number of subscriptions stored
+func (t *Trie) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.n
+}
+
+// Match returns the subscriptions matching the topic name, sorted by
+// subscriber, share name and filter. A subscriber matched by overlapping
+// filters appears once with the maximum QoS of them. A shared subscription
+// appears once per share group, made of a share name and a filter, and every
+// member of a group is returned: choosing the one member a message is
+// delivered to is left to the caller. The filters starting with a wildcard
+// do not match the names starting with $.
+func (t *Trie) Match(name string) []Subscription {
+	var subs []Subscription
+	t.mu.RLock()
+	t.root.match(name, strings.HasPrefix(name, "$"), &subs)
+	t.mu.RUnlock()
+
+	if len(subs) < 2 {
+		return subs
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Subscriber != subs[j].Subscriber {
+			return subs[i].Subscriber < subs[j].Subscriber
+		}
+		if subs[i].Share != subs[j].Share {
+			return subs[i].Share < subs[j].Share
+		}
+		return subs[i].Filter < subs[j].Filter
+	})
+	out := subs[:1]
+	for _, s := range subs[1:] {
+		last := &out[len(out)-1]
+		if s.Subscriber != last.Subscriber || s.Share != last.Share || s.Filter != last.Filter {
+			out = append(out, s)
+		} else if s.QoS > last.QoS {
+			last.QoS = s.QoS
+		}
+	}
+	return out
+}
+
+// match appends the subscriptions below n matching the levels left in name,
+// dollar skips the wildcards on the first level of a name starting with $
+func (n *node) match(name string, dollar bool, subs *[]Subscription) {
+	level, rest, more := strings.Cut(name, "/")
+	if n.hash != nil && !dollar {
+		n.hash.collect(subs)
+	}
+	if c := n.children[level]; c != nil {
+		c.matchRest(rest, more, subs)
+	}
+	if n.plus != nil && !dollar {
+		n.plus.matchRest(rest, more, subs)
+	}
+}
+
+// matchRest matches the levels left in rest below n, or n itself on the last
+// level
+func (n *node) matchRest(rest string, more bool, subs *[]Subscription) {
+	if more {
+		n.match(rest, false, subs)
+		return
+	}
+	n.collect(subs)
+	// the multi-level wildcard matches its parent level too
+	if n.hash != nil {
+		n.hash.collect(subs)
+	}
+}
+
+// collect appends the subscriptions of n
+func (n *node) collect(subs *[]Subscription) {
+	for k, qos := range n.subs {
+		s := Subscription{Subscriber: k.subscriber, Share: k.share, QoS: qos}
+		if k.share != "" {
+			s.Filter = n.filter
+		}
+		*subs = append(*subs, s)
+	}
+}
@@ -0,0 +1,148 @@
+package topic
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrieMatch(t *testing.T) {
+	var tr Trie
+	filters := []string{"#", "+", "/+", "+/+", "a", "a/#", "a/+", "a/b", "a/+/c", "a/b/#", "a/+/+/d", "$SYS/#", "$SYS/+", "+/b"}
+	for _, f := range filters {
+		replaced, err := tr.Subscribe(f, Subscription{Subscriber: f})
+		assert.NoError(t, err)
+		assert.False(t, replaced)
+	}
+	assert.Equal(t, len(filters), tr.Len())
+
+	for name, want := range map[string][]string{
+		"a":           {"#", "+", "a", "a/#"},
+		"a/b":         {"#", "+/+", "+/b", "a/#", "a/+", "a/b", "a/b/#"},
+		"a/x":         {"#", "+/+", "a/#", "a/+"},
+		"a/b/c":       {"#", "a/#", "a/+/c", "a/b/#"},
+		"a/x/y/d":     {"#", "a/#", "a/+/+/d"},
+		"/x":          {"#", "+/+", "/+"},
+		"b":           {"#", "+"},
+		"a/":          {"#", "+/+", "a/#", "a/+"},
+		"$SYS":        {"$SYS/#"},
+		"$SYS/broker": {"$SYS/#", "$SYS/+"},
+		"$other/b":    nil,
+	} {
+		var got []string
+		for _, s := range tr.Match(name) {
+			got = append(got, s.Subscriber)
+		}
+		assert.Equal(t, want, got, name)
+	}
+}
+
+func TestTrieOverlap(t *testing.T) {
+	var tr Trie
+	tr.Subscribe("a/+", Subscription{Subscriber: "c1", QoS: 1})
+	tr.Subscribe("a/#", Subscription{Subscriber: "c1", QoS: 2})
+	tr.Subscribe("a/b", Subscription{Subscriber: "c1", QoS: 0})
+	tr.Subscribe("a/b", Subscription{Subscriber: "c2", QoS: 1})
+	tr.Subscribe("a/+", Subscription{Subscriber: "c2", Share: "g", QoS: 2})
+	tr.Subscribe("a/b", Subscription{Subscriber: "c2", Share: "g", QoS: 0})
+	tr.Subscribe("#", Subscription{Subscriber: "c3", Share: "g", QoS: 1})
+
+	// a share group is a share name and a filter
+	assert.Equal(t, []Subscription{
+		{Subscriber: "c1", QoS: 2},
+		{Subscriber: "c2", QoS: 1},
+		{Subscriber: "c2", Share: "g", QoS: 2, Filter: "a/+"},
+		{Subscriber: "c2", Share: "g", QoS: 0, Filter: "a/b"},
+		{Subscriber: "c3", Share: "g", QoS: 1, Filter: "#"},
+	}, tr.Match("a/b"))
+
+	// a new subscription to the same filter replaces the old one
+	replaced, err := tr.Subscribe("a/#", Subscription{Subscriber: "c1", QoS: 0})
+	assert.NoError(t, err)
+	assert.True(t, replaced)
+	assert.Equal(t, []Subscription{
+		{Subscriber: "c1", QoS: 1},
+		{Subscriber: "c2", Share: "g", QoS: 2, Filter: "a/+"},
+		{Subscriber: "c3", Share: "g", QoS: 1, Filter: "#"},
+	}, tr.Match("a/c"))
+	assert.Equal(t, 7, tr.Len())
+
+	// every member of a share group is returned
+	tr.Subscribe("a/+", Subscription{Subscriber: "c3", Share: "g", QoS: 1})
+	assert.Equal(t, []Subscription{
+		{Subscriber: "c1", QoS: 1},
+		{Subscriber: "c2", Share: "g", QoS: 2, Filter: "a/+"},
+		{Subscriber: "c3", Share: "g", QoS: 1, Filter: "#"},
+		{Subscriber: "c3", Share: "g", QoS: 1, Filter: "a/+"},
+	}, tr.Match("a/c"))
+}
+
+func TestTrieUnsubscribe(t *testing.T) {
+	var tr Trie
+	tr.Subscribe("a/+/c", Subscription{Subscriber: "c1"})
+	tr.Subscribe("a/+/c", Subscription{Subscriber: "c2"})
+	tr.Subscribe("a/#", Subscription{Subscriber: "c1"})
+
+	assert.False(t, tr.Unsubscribe("a/+", Subscription{Subscriber: "c1"}))
+	assert.False(t, tr.Unsubscribe("a/+/c", Subscription{Subscriber: "c3"}))
+	assert.False(t, tr.Unsubscribe("a/+/c", Subscription{Subscriber: "c1", Share: "g"}))
+	assert.True(t, tr.Unsubscribe("a/+/c", Subscription{Subscriber: "c1", QoS: 2}))
+	assert.False(t, tr.Unsubscribe("a/+/c", Subscription{Subscriber: "c1"}))
+	assert.Equal(t, []Subscription{{Subscriber: "c1"}, {Subscriber: "c2"}}, tr.Match("a/b/c"))
+
+	assert.True(t, tr.Unsubscribe("a/+/c", Subscription{Subscriber: "c2"}))
+	assert.True(t, tr.Unsubscribe("a/#", Subscription{Subscriber: "c1"}))
+	assert.Equal(t, 0, tr.Len())
+	// the empty levels are dropped
+	assert.True(t, tr.root.empty())
+}
+
+func TestTrieInvalid(t *testing.T) {
+	var tr Trie
+	for _, f := range []string{"", "a/#/b", "a+"} {
+		_, err := tr.Subscribe(f, Subscription{Subscriber: "c"})
+		assert.Error(t, err, f)
+	}
+	_, err := tr.Subscribe("a", Subscription{Subscriber: "c", Share: "g/h"})
+	assert.ErrorIs(t, err, ErrShareName)
+	assert.Equal(t, 0, tr.Len())
+}
+
+func TestTrieConcurrent(t *testing.T) {
+	var (
+		tr Trie
+		wg sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := strconv.Itoa(i)
+			for j := 0; j < 100; j++ {
+				f := fmt.Sprintf("a/%d/+", j)
+				tr.Subscribe(f, Subscription{Subscriber: c})
+				tr.Match(fmt.Sprintf("a/%d/b", j))
+				tr.Unsubscribe(f, Subscription{Subscriber: c})
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 0, tr.Len())
+	assert.True(t, tr.root.empty())
+}
+
+func BenchmarkTrieMatch(b *testing.B) {
+	var tr Trie
+	for i := 0; i < 100000; i++ {
+		tr.Subscribe(fmt.Sprintf("a/%d/%d/+", i%100, i), Subscription{Subscriber: strconv.Itoa(i)})
+	}
+	tr.Subscribe("a/#", Subscription{Subscriber: "all"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr.Match("a/42/4242/x")
+	}
+}